@@ -1,21 +1,36 @@
 package music
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os/exec"
-	"regexp"
-
-	h "github.com/barsanuphe/helpers"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	acoustidURL = "http://api.acoustid.org/v2/lookup"
+	acoustidURL  = "http://api.acoustid.org/v2"
+	acoustidMeta = "recordings releases tracks"
+	// fpcalcDefaultAlgorithm is chromaprint's default, as numbered by fpcalc.
+	fpcalcDefaultAlgorithm = chromaprintAlgorithm + 1
+)
+
+var (
+	// AcoustidOptions for new AcoustID clients.
+	AcoustidOptions = ProviderOptions{}
+	// AcoustidBatchSize is the maximum number of fingerprints sent in one request.
+	AcoustidBatchSize = 10
+	// AcoustidRateLimiter for all AcoustID requests: 3 per second.
+	AcoustidRateLimiter = NewRateLimiter(time.Second/3, 3)
+	// FpcalcTimeout after which fpcalc is killed, if the context has no earlier deadline.
+	FpcalcTimeout = time.Minute
 )
 
 // AcoustidResults is a struct describing the JSON response from Acoustid
@@ -78,84 +93,181 @@ type AcoustidResults struct {
 
 // AcousticID allows getting information about a track from its contents
 type AcousticID struct {
-	APIKey      string
-	Fingerprint string
-	Duration    string
+	APIKey         string
+	Options        ProviderOptions
+	Fingerprint    string
+	Duration       string
+	RawFingerprint []uint32
+	// Length of audio to fingerprint, in seconds (fpcalc -length, 120 if 0).
+	Length int
+	// Algorithm used by chromaprint, as numbered by fpcalc (-algorithm, 2 if 0).
+	Algorithm int
+	// UserKey of the AcoustID account submitting fingerprints.
+	UserKey string
+}
+
+// fpcalcOutput is the output of fpcalc -json, with or without -raw.
+type fpcalcOutput struct {
+	Duration    float64         `json:"duration"`
+	Fingerprint json.RawMessage `json:"fingerprint"`
 }
 
 // NewAcoustid set up with api key
 func NewAcoustid(key string) *AcousticID {
-	return &AcousticID{APIKey: key}
+	return &AcousticID{APIKey: key, Options: AcoustidOptions}
+}
+
+// acoustidStatus of every AcoustID response.
+type acoustidStatus struct {
+	Status string `json:"status"`
+	Error  struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
 }
 
-// CalculateFingerprint for a given track
+// CalculateFingerprint for a given track.
+// FLAC files are fingerprinted natively with the default algorithm,
+// other formats and algorithms need fpcalc.
 func (a *AcousticID) CalculateFingerprint(path string) error {
-	var err error
+	return a.CalculateFingerprintContext(context.Background(), path)
+}
+
+// CalculateFingerprintContext for a given track, stopping native fingerprinting
+// or killing fpcalc if the context is done.
+func (a *AcousticID) CalculateFingerprintContext(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	length := a.Length
+	if length == 0 {
+		length = ChromaprintMaxDuration
+	}
+	algorithm := a.Algorithm
+	if algorithm == 0 {
+		algorithm = fpcalcDefaultAlgorithm
+	}
+	if strings.ToLower(filepath.Ext(path)) == flacExtension && algorithm == fpcalcDefaultAlgorithm {
+		raw, duration, err := RawFingerprintFlacContext(ctx, path, length)
+		if err != nil {
+			return err
+		}
+		a.RawFingerprint, a.Fingerprint, a.Duration = raw, EncodeFingerprint(raw), strconv.Itoa(duration)
+		return nil
+	}
 	fpcalc, err := exec.LookPath("fpcalc")
 	if err != nil {
 		fmt.Println("Needs fpcalc, installed with chromaprint!")
 		return err
 	}
 	// run fpcalc on path
-	out, err := exec.Command(fpcalc, path).Output()
+	ctx, cancel := context.WithTimeout(ctx, FpcalcTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, fpcalc, "-json", "-raw", "-length", strconv.Itoa(length), "-algorithm", strconv.Itoa(algorithm), path).Output()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
 		return err
 	}
-	data := string(out)
-	// get duration & fingerprint
-	r := regexp.MustCompile(`DURATION=(\d+)\nFINGERPRINT=([-\w]+)`)
-	if r.MatchString(data) {
-		a.Duration = r.FindStringSubmatch(data)[1]
-		a.Fingerprint = r.FindStringSubmatch(data)[2]
-		return nil
+	raw, duration, err := parseFpcalc(out)
+	if err != nil {
+		return err
 	}
-	return errors.New("Could not find duration and fingerprint.")
+	a.RawFingerprint, a.Fingerprint, a.Duration = raw, encodeFingerprint(raw, byte(algorithm-1)), strconv.Itoa(duration)
+	return nil
+}
+
+// parseFpcalc output, returning the raw fingerprint and the duration in seconds.
+// The fingerprint is either an array of integers (-raw) or a compressed string.
+func parseFpcalc(out []byte) ([]uint32, int, error) {
+	output := fpcalcOutput{}
+	if err := json.Unmarshal(out, &output); err != nil {
+		return nil, 0, err
+	}
+	if len(output.Fingerprint) == 0 {
+		return nil, 0, errors.New("Could not find duration and fingerprint.")
+	}
+	// older versions print the raw fingerprint as signed integers
+	values := []int64{}
+	if err := json.Unmarshal(output.Fingerprint, &values); err == nil {
+		raw := make([]uint32, len(values))
+		for i, v := range values {
+			raw[i] = uint32(v)
+		}
+		return raw, int(output.Duration), nil
+	}
+	var fingerprint string
+	if err := json.Unmarshal(output.Fingerprint, &fingerprint); err != nil {
+		return nil, 0, err
+	}
+	raw, _, err := DecodeFingerprint(fingerprint)
+	if err != nil {
+		return nil, 0, err
+	}
+	return raw, int(output.Duration), nil
 }
 
 // LookUp Acoustid database once we have the fingerprint
 func (a *AcousticID) LookUp() (*AcoustidResults, error) {
+	return a.LookUpContext(context.Background())
+}
+
+// LookUpContext Acoustid database once we have the fingerprint, until the context is done.
+func (a *AcousticID) LookUpContext(ctx context.Context) (*AcoustidResults, error) {
 	// get fingerprint
 	if a.Fingerprint == "" {
 		return nil, errors.New("Must fingerprint first")
 	}
-	// setting up the form
-	buffer := new(bytes.Buffer)
-	w := multipart.NewWriter(buffer)
-	errs := []error{}
-	errs = append(errs, w.WriteField("format", "json"))
-	errs = append(errs, w.WriteField("client", a.APIKey))
-	errs = append(errs, w.WriteField("duration", a.Duration))
-	errs = append(errs, w.WriteField("fingerprint", a.Fingerprint))
-	errs = append(errs, w.WriteField("meta", "recordings releases tracks"))
-	if err := h.CheckErrors(errs...); err != nil {
+	form := url.Values{}
+	form.Set("format", "json")
+	form.Set("client", a.APIKey)
+	form.Set("duration", a.Duration)
+	form.Set("fingerprint", a.Fingerprint)
+	form.Set("meta", acoustidMeta)
+	results := AcoustidResults{}
+	if err := a.request(withCaching(ctx, true), "POST", "/lookup", form, &results); err != nil {
 		return nil, err
 	}
-	w.Close()
+	return &results, nil
+}
 
-	req, err := http.NewRequest("POST", acoustidURL, buffer)
+// request an AcoustID endpoint with a form, and parse the JSON response in v.
+func (a *AcousticID) request(ctx context.Context, method, endpoint string, form url.Values, v interface{}) error {
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequestWithContext(ctx, method, a.Options.url(acoustidURL, endpoint)+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, a.Options.url(acoustidURL, endpoint), strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-	req.Header.Set("Content-Type", w.FormDataContentType())
 	// TODO compress form? req.Header.Set("Content-Encoding", "gzip")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	req.Header.Set("User-Agent", a.Options.userAgent(UserAgent))
+	resp, err := a.Options.httpClient(AcoustidRateLimiter).Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
-	// parse response
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Returned status: " + resp.Status)
-	}
-	resultBytes, err := ioutil.ReadAll(resp.Body)
+	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	results := AcoustidResults{}
-	err = json.Unmarshal(resultBytes, &results)
-	if results.Status != "ok" {
-		return nil, errors.New("Acoustid Error")
+	// errors come with a message, and usually a 400 status
+	status := acoustidStatus{}
+	if err := json.Unmarshal(data, &status); err != nil || status.Status != "ok" {
+		switch {
+		case status.Error.Message != "":
+			return errors.New("Acoustid Error: " + status.Error.Message)
+		case resp.StatusCode != http.StatusOK:
+			return errors.New("Returned status: " + resp.Status)
+		}
+		return errors.New("Acoustid Error")
 	}
-	return &results, nil
+	return json.Unmarshal(data, v)
 }
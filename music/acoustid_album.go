@@ -0,0 +1,247 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// AcoustidFingerprint of an audio file.
+type AcoustidFingerprint struct {
+	Fingerprint string
+	Duration    string // in seconds
+}
+
+// acoustidBatchResults is the JSON response to a lookup of several fingerprints.
+type acoustidBatchResults struct {
+	Fingerprints []json.RawMessage `json:"fingerprints"`
+}
+
+// AcoustidAlbumCandidate is a release medium explaining some of the tracks of a local album.
+type AcoustidAlbumCandidate struct {
+	Release *Release
+	Medium  int
+	// Score is the sum of the AcoustID scores of the tracks found at the
+	// right position, divided by the number of local tracks.
+	Score float64
+	// Tracks found at the right position, and the medium track count.
+	Tracks     int
+	TrackCount int
+	// Recordings identified for each local file explained by this release, by path.
+	Recordings map[string]string
+}
+
+// Complete if the medium has as many tracks as the local album, and all were identified.
+func (c AcoustidAlbumCandidate) Complete(localTracks int) bool {
+	return c.TrackCount == localTracks && c.Tracks == localTracks
+}
+
+// IdentifyAlbum fingerprints every FLAC file in a directory, looks them up,
+// and returns the release media that explain the most tracks, best first.
+func (a *AcousticID) IdentifyAlbum(dir string) ([]AcoustidAlbumCandidate, error) {
+	return a.IdentifyAlbumContext(context.Background(), dir)
+}
+
+// IdentifyAlbumContext in a directory, until the context is done.
+func (a *AcousticID) IdentifyAlbumContext(ctx context.Context, dir string) ([]AcoustidAlbumCandidate, error) {
+	tracks, err := ReadLocalAlbum(dir)
+	if err != nil {
+		return nil, err
+	}
+	fingerprints, err := a.FingerprintTracksContext(ctx, tracks)
+	if err != nil {
+		return nil, err
+	}
+	results, err := a.LookUpBatchContext(ctx, fingerprints)
+	if err != nil {
+		return nil, err
+	}
+	candidates := VoteAlbum(tracks, results)
+	if len(candidates) == 0 {
+		return nil, errors.New("No release found for " + dir)
+	}
+	return candidates, nil
+}
+
+// FingerprintTracks of an album, with the same options as a.
+func (a *AcousticID) FingerprintTracks(tracks []LocalTrack) ([]AcoustidFingerprint, error) {
+	return a.FingerprintTracksContext(context.Background(), tracks)
+}
+
+// FingerprintTracksContext of an album, until the context is done.
+func (a *AcousticID) FingerprintTracksContext(ctx context.Context, tracks []LocalTrack) ([]AcoustidFingerprint, error) {
+	fingerprints := []AcoustidFingerprint{}
+	for _, t := range tracks {
+		f := *a
+		if err := f.CalculateFingerprintContext(ctx, t.Path); err != nil {
+			return nil, err
+		}
+		fingerprints = append(fingerprints, AcoustidFingerprint{Fingerprint: f.Fingerprint, Duration: f.Duration})
+	}
+	return fingerprints, nil
+}
+
+// LookUpBatch of fingerprints, AcoustidBatchSize per request.
+// Results are in the same order as the fingerprints.
+func (a *AcousticID) LookUpBatch(fingerprints []AcoustidFingerprint) ([]*AcoustidResults, error) {
+	return a.LookUpBatchContext(context.Background(), fingerprints)
+}
+
+// LookUpBatchContext of fingerprints, until the context is done.
+func (a *AcousticID) LookUpBatchContext(ctx context.Context, fingerprints []AcoustidFingerprint) ([]*AcoustidResults, error) {
+	results := []*AcoustidResults{}
+	for start := 0; start < len(fingerprints); start += AcoustidBatchSize {
+		end := start + AcoustidBatchSize
+		if end > len(fingerprints) {
+			end = len(fingerprints)
+		}
+		batch, err := a.lookUpBatch(ctx, fingerprints[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+	}
+	return results, nil
+}
+
+func (a *AcousticID) lookUpBatch(ctx context.Context, fingerprints []AcoustidFingerprint) ([]*AcoustidResults, error) {
+	form := url.Values{}
+	form.Set("format", "json")
+	form.Set("client", a.APIKey)
+	form.Set("meta", acoustidMeta)
+	for i, f := range fingerprints {
+		if f.Fingerprint == "" {
+			return nil, errors.New("Must fingerprint first")
+		}
+		form.Set("fingerprint."+strconv.Itoa(i), f.Fingerprint)
+		form.Set("duration."+strconv.Itoa(i), f.Duration)
+	}
+	batch := acoustidBatchResults{}
+	if err := a.request(withCaching(ctx, true), "POST", "/lookup", form, &batch); err != nil {
+		return nil, err
+	}
+	results := make([]*AcoustidResults, len(fingerprints))
+	for _, data := range batch.Fingerprints {
+		var index struct {
+			Index json.Number `json:"index"`
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		i, err := strconv.Atoi(index.Index.String())
+		if err != nil || i < 0 || i >= len(results) {
+			return nil, errors.New("Invalid fingerprint index in AcoustID response")
+		}
+		results[i] = &AcoustidResults{}
+		if err := json.Unmarshal(data, results[i]); err != nil {
+			return nil, err
+		}
+		results[i].Status = "ok"
+	}
+	// fingerprints without results may be left out
+	for i := range results {
+		if results[i] == nil {
+			results[i] = &AcoustidResults{Status: "ok"}
+		}
+	}
+	return results, nil
+}
+
+// VoteAlbum aggregates the AcoustID results of each local track.
+// Each track votes, with its best score, for the release media where one of
+// its recordings appears at the track position (its TRACKNUMBER and
+// DISCNUMBER tags if any, its place on its disc otherwise).
+// Tracks without results, nil if their lookup failed, do not vote.
+func VoteAlbum(tracks []LocalTrack, results []*AcoustidResults) []AcoustidAlbumCandidate {
+	type key struct {
+		release string
+		medium  int
+	}
+	all := AcoustidResults{}
+	positions := discPositions(tracks)
+	votes := map[key]*AcoustidAlbumCandidate{}
+	order := []key{}
+	for i, res := range results {
+		if i >= len(tracks) {
+			break
+		}
+		if res == nil {
+			// failed lookup, the other tracks still vote
+			continue
+		}
+		all.Results = append(all.Results, res.Results...)
+		position, disc := positions[i], tracks[i].DiscNumber
+		// best vote of this track for each release medium
+		best := map[key]float64{}
+		recordings := map[key]string{}
+		trackCounts := map[key]int{}
+		for _, result := range res.Results {
+			for _, recording := range result.Recordings {
+				for _, rel := range recording.Releases {
+					for _, m := range rel.Mediums {
+						if disc != 0 && m.Position != disc {
+							continue
+						}
+						for _, t := range m.Tracks {
+							k := key{rel.ID, m.Position}
+							if t.Position != position || result.Score <= best[k] {
+								continue
+							}
+							best[k], recordings[k], trackCounts[k] = result.Score, recording.ID, m.TrackCount
+						}
+					}
+				}
+			}
+		}
+		for k, score := range best {
+			c, ok := votes[k]
+			if !ok {
+				c = &AcoustidAlbumCandidate{Medium: k.medium, Recordings: map[string]string{}}
+				votes[k] = c
+				order = append(order, k)
+			}
+			c.Score += score / float64(len(tracks))
+			c.Tracks++
+			c.TrackCount = trackCounts[k]
+			c.Recordings[tracks[i].Path] = recordings[k]
+		}
+	}
+
+	releases := map[string]*Release{}
+	for _, r := range all.Releases() {
+		releases[r.ID] = r
+	}
+	candidates := []AcoustidAlbumCandidate{}
+	for _, k := range order {
+		c := votes[k]
+		c.Release = releases[k.release]
+		candidates = append(candidates, *c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		// prefer media with the same number of tracks as the local album
+		return a.TrackCount == len(tracks) && b.TrackCount != len(tracks)
+	})
+	return candidates
+}
+
+// discPositions of sorted local tracks: their TRACKNUMBER, or their place
+// among the tracks of the same disc if untagged.
+func discPositions(tracks []LocalTrack) []int {
+	positions := make([]int, len(tracks))
+	counts := map[int]int{}
+	for i, t := range tracks {
+		counts[t.DiscNumber]++
+		positions[i] = t.TrackNumber
+		if positions[i] == 0 {
+			positions[i] = counts[t.DiscNumber]
+		}
+	}
+	return positions
+}
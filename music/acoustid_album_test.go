@@ -0,0 +1,234 @@
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testAcoustidRelease struct {
+	id                           string
+	medium, trackCount, position int
+}
+
+// testAcoustidResults for a fingerprint matching a recording on several releases.
+func testAcoustidResults(t *testing.T, score float64, recording string, releases ...testAcoustidRelease) *AcoustidResults {
+	rels := []string{}
+	for _, r := range releases {
+		rels = append(rels, fmt.Sprintf(`{"id": %q, "title": %q, "mediums": [{"format": "CD", "position": %d, "track_count": %d,
+			"tracks": [{"id": "%s-%d", "position": %d, "title": %q}]}]}`,
+			r.id, r.id, r.medium, r.trackCount, r.id, r.position, r.position, recording))
+	}
+	data := fmt.Sprintf(`{"status": "ok", "results": [{"id": "fp-%s", "score": %v, "recordings": [
+		{"id": %q, "title": %q, "duration": 200, "releases": [%s]}]}]}`,
+		recording, score, recording, recording, strings.Join(rels, ","))
+	results := &AcoustidResults{}
+	require.Nil(t, json.Unmarshal([]byte(data), results))
+	return results
+}
+
+func TestVoteAlbum(t *testing.T) {
+	fmt.Println("+ Testing AcoustID album identification...")
+	check := assert.New(t)
+
+	tracks := []LocalTrack{{Path: "1.flac"}, {Path: "2.flac"}, {Path: "3.flac"}}
+	results := []*AcoustidResults{
+		testAcoustidResults(t, 0.9, "r1",
+			testAcoustidRelease{"ep", 1, 3, 1},
+			testAcoustidRelease{"deluxe", 1, 5, 1},
+			testAcoustidRelease{"compilation", 1, 18, 7}),
+		testAcoustidResults(t, 0.9, "r2",
+			testAcoustidRelease{"ep", 1, 3, 2},
+			testAcoustidRelease{"deluxe", 1, 5, 2},
+			testAcoustidRelease{"single", 1, 2, 1}),
+		testAcoustidResults(t, 0.6, "r3",
+			testAcoustidRelease{"ep", 1, 3, 3},
+			testAcoustidRelease{"deluxe", 1, 5, 3}),
+	}
+
+	candidates := VoteAlbum(tracks, results)
+	require.Equal(t, 2, len(candidates), "Only releases with tracks at the right position get votes")
+	c := candidates[0]
+	check.Equal("ep", c.Release.ID)
+	check.Equal(1, c.Medium)
+	check.InDelta(0.8, c.Score, 0.0001)
+	check.Equal(3, c.Tracks)
+	check.True(c.Complete(len(tracks)))
+	check.Equal("r2", c.Recordings["2.flac"])
+	check.Equal(3, c.Release.TrackCount())
+	check.Equal("deluxe", candidates[1].Release.ID)
+	check.False(candidates[1].Complete(len(tracks)))
+
+	// second disc of a multi disc release, tagged
+	tracks = []LocalTrack{{Path: "2-1.flac", DiscNumber: 2, TrackNumber: 1}, {Path: "2-2.flac", DiscNumber: 2, TrackNumber: 2}}
+	results = []*AcoustidResults{
+		testAcoustidResults(t, 0.9, "r1",
+			testAcoustidRelease{"double", 2, 2, 1},
+			testAcoustidRelease{"double-reissue", 1, 2, 1}),
+		testAcoustidResults(t, 0.7, "r2",
+			testAcoustidRelease{"double", 2, 2, 2},
+			testAcoustidRelease{"double-reissue", 1, 2, 2}),
+	}
+	candidates = VoteAlbum(tracks, results)
+	require.Equal(t, 1, len(candidates))
+	check.Equal("double", candidates[0].Release.ID)
+	check.Equal(2, candidates[0].Medium)
+	check.InDelta(0.8, candidates[0].Score, 0.0001)
+
+	// a failed lookup does not hide the next tracks
+	candidates = VoteAlbum(tracks, []*AcoustidResults{nil, results[1]})
+	require.Equal(t, 1, len(candidates))
+	check.Equal("double", candidates[0].Release.ID)
+	check.Equal(1, candidates[0].Tracks)
+	check.Equal("r2", candidates[0].Recordings["2-2.flac"])
+	check.InDelta(0.35, candidates[0].Score, 0.0001)
+
+	// untagged track numbers are counted from the start of each disc
+	tracks = []LocalTrack{{Path: "1-a.flac", DiscNumber: 1}, {Path: "2-a.flac", DiscNumber: 2}, {Path: "2-b.flac", DiscNumber: 2}}
+	check.Equal([]int{1, 1, 2}, discPositions(tracks))
+	results = []*AcoustidResults{
+		testAcoustidResults(t, 0.9, "r1", testAcoustidRelease{"double", 1, 1, 1}),
+		testAcoustidResults(t, 0.9, "r2", testAcoustidRelease{"double", 2, 2, 1}),
+		testAcoustidResults(t, 0.9, "r3", testAcoustidRelease{"double", 2, 2, 2}),
+	}
+	candidates = VoteAlbum(tracks, results)
+	require.Equal(t, 2, len(candidates))
+	check.Equal(2, candidates[0].Medium)
+	check.Equal(2, candidates[0].Tracks)
+	check.Equal("r3", candidates[0].Recordings["2-b.flac"])
+}
+
+// testAcoustidServer is a fake AcoustID API. Known fingerprints are found
+// at their position on an "album" release.
+type testAcoustidServer struct {
+	*httptest.Server
+	positions   map[string]int
+	requests    map[string]int
+	submissions []url.Values
+	polls       int
+}
+
+func newTestAcoustidServer(t *testing.T) *testAcoustidServer {
+	s := &testAcoustidServer{positions: map[string]int{}, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests[r.URL.Path]++
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("client") != "key" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status": "error", "error": {"code": 4, "message": "invalid API key"}}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/lookup":
+			fingerprints := []string{}
+			for i := 0; r.FormValue("fingerprint."+strconv.Itoa(i)) != ""; i++ {
+				position, ok := s.positions[r.FormValue("fingerprint."+strconv.Itoa(i))]
+				if !ok {
+					continue
+				}
+				fingerprints = append(fingerprints, fmt.Sprintf(`{"index": "%d", "results": [{"id": "fp-%d", "score": 0.9,
+					"recordings": [{"id": "rec-%d", "releases": [{"id": "album", "title": "Album", "mediums": [
+					{"position": 1, "track_count": 3, "tracks": [{"position": %d, "title": "Track %d"}]}]}]}]}]}`,
+					i, position, position, position, position))
+			}
+			fmt.Fprintf(w, `{"status": "ok", "fingerprints": [%s]}`, strings.Join(fingerprints, ","))
+		case "/submit":
+			if r.FormValue("user") != "user-key" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"status": "error", "error": {"code": 6, "message": "invalid user API key"}}`)
+				return
+			}
+			require.Nil(t, r.ParseForm())
+			s.submissions = append(s.submissions, r.PostForm)
+			statuses := []string{}
+			for i := 0; r.FormValue("fingerprint."+strconv.Itoa(i)) != ""; i++ {
+				statuses = append(statuses, fmt.Sprintf(`{"index": %d, "id": %d, "status": "pending"}`, i, 100*len(s.submissions)+i))
+			}
+			fmt.Fprintf(w, `{"status": "ok", "submissions": [%s]}`, strings.Join(statuses, ","))
+		case "/submission_status":
+			// imported on the second poll
+			s.polls++
+			statuses := []string{}
+			for _, id := range r.URL.Query()["id"] {
+				if s.polls < 2 {
+					statuses = append(statuses, fmt.Sprintf(`{"id": %s, "status": "pending"}`, id))
+				} else {
+					statuses = append(statuses, fmt.Sprintf(`{"id": %s, "status": "imported", "result": {"id": "acoustid-%s"}}`, id, id))
+				}
+			}
+			fmt.Fprintf(w, `{"status": "ok", "submissions": [%s]}`, strings.Join(statuses, ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func TestAcoustidBatchLookUp(t *testing.T) {
+	fmt.Println("+ Testing AcoustID batch lookups...")
+	check := assert.New(t)
+
+	server := newTestAcoustidServer(t)
+	defer server.Close()
+	defer func(size int) { AcoustidBatchSize = size }(AcoustidBatchSize)
+	AcoustidBatchSize = 2
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for i := 1; i <= 3; i++ {
+		music := testMusic(4, 11025, 1, uint32(10+i))
+		samples := make([]int32, len(music))
+		for j := range music {
+			samples[j] = int32(music[j])
+		}
+		writeTestAudioFlac(t, filepath.Join(dir, fmt.Sprintf("%02d.flac", i)), 11025, [][]int32{samples})
+	}
+
+	a := NewAcoustid("key")
+	a.Options.BaseURL = server.URL
+	tracks, err := ReadLocalAlbum(dir)
+	require.Nil(t, err)
+	fingerprints, err := a.FingerprintTracks(tracks)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(fingerprints))
+	check.Equal("", a.Fingerprint, "Fingerprinting tracks does not change the single track state")
+	for i, f := range fingerprints {
+		check.Equal("4", f.Duration)
+		server.positions[f.Fingerprint] = i + 1
+	}
+
+	candidates, err := a.IdentifyAlbum(dir)
+	require.Nil(t, err)
+	check.Equal(2, server.requests["/lookup"])
+	require.Equal(t, 1, len(candidates))
+	check.Equal("album", candidates[0].Release.ID)
+	check.True(candidates[0].Complete(3))
+	check.Equal("rec-2", candidates[0].Recordings[tracks[1].Path])
+
+	// unknown fingerprints have no results
+	results, err := a.LookUpBatch([]AcoustidFingerprint{{"unknown", "100"}, fingerprints[2]})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(results))
+	check.Equal(0, len(results[0].Results))
+	check.Equal("rec-3", results[1].Results[0].Recordings[0].ID)
+	check.Equal("ok", results[1].Status)
+
+	_, err = a.LookUpBatch([]AcoustidFingerprint{{}})
+	check.NotNil(err)
+	a.APIKey = "wrong"
+	_, err = a.LookUpBatch(fingerprints)
+	require.NotNil(t, err)
+	check.Equal("Acoustid Error: invalid API key", err.Error())
+}
@@ -0,0 +1,205 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	acoustidClientVersion = "0.1"
+	acoustidImported      = "imported"
+)
+
+// AcoustidSubmission of a fingerprint, linked to a MusicBrainz recording.
+// Metadata is only used by AcoustID when there is no recording ID.
+type AcoustidSubmission struct {
+	AcoustidFingerprint
+	RecordingID string
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Year        int
+	TrackNumber int
+	DiscNumber  int
+	FileFormat  string
+}
+
+// NewAcoustidSubmission of the fingerprint of a local track, with its metadata.
+func NewAcoustidSubmission(t LocalTrack, fingerprint AcoustidFingerprint) AcoustidSubmission {
+	return AcoustidSubmission{
+		AcoustidFingerprint: fingerprint,
+		RecordingID:         t.RecordingID,
+		Title:               t.Title,
+		Artist:              t.Artist,
+		Album:               t.Album,
+		AlbumArtist:         t.AlbumArtist,
+		Year:                t.Year,
+		TrackNumber:         t.TrackNumber,
+		DiscNumber:          t.DiscNumber,
+		FileFormat:          strings.ToUpper(strings.TrimPrefix(filepath.Ext(t.Path), ".")),
+	}
+}
+
+// AcoustidSubmissionStatus of a submitted fingerprint.
+type AcoustidSubmissionStatus struct {
+	Index  json.Number `json:"index"` // of the submission in its request
+	ID     int         `json:"id"`
+	Status string      `json:"status"` // "pending" or "imported"
+	Result struct {
+		ID string `json:"id"` // AcoustID, once imported
+	} `json:"result"`
+}
+
+// Imported if AcoustID has processed the submission.
+func (s AcoustidSubmissionStatus) Imported() bool {
+	return s.Status == acoustidImported
+}
+
+// acoustidSubmissions is the JSON response to a submission or a status request.
+type acoustidSubmissions struct {
+	Submissions []AcoustidSubmissionStatus `json:"submissions"`
+}
+
+// Submit fingerprints to AcoustID, AcoustidBatchSize per request.
+// Statuses are in the same order as the submissions.
+func (a *AcousticID) Submit(submissions []AcoustidSubmission) ([]AcoustidSubmissionStatus, error) {
+	return a.SubmitContext(context.Background(), submissions)
+}
+
+// SubmitContext fingerprints to AcoustID, until the context is done.
+func (a *AcousticID) SubmitContext(ctx context.Context, submissions []AcoustidSubmission) ([]AcoustidSubmissionStatus, error) {
+	if a.UserKey == "" {
+		return nil, errors.New("AcoustID user key is required to submit fingerprints")
+	}
+	statuses := []AcoustidSubmissionStatus{}
+	for start := 0; start < len(submissions); start += AcoustidBatchSize {
+		end := start + AcoustidBatchSize
+		if end > len(submissions) {
+			end = len(submissions)
+		}
+		batch, err := a.submit(ctx, submissions[start:end])
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, batch...)
+	}
+	return statuses, nil
+}
+
+func (a *AcousticID) submit(ctx context.Context, submissions []AcoustidSubmission) ([]AcoustidSubmissionStatus, error) {
+	form := url.Values{}
+	form.Set("format", "json")
+	form.Set("client", a.APIKey)
+	form.Set("clientversion", acoustidClientVersion)
+	form.Set("user", a.UserKey)
+	for i, s := range submissions {
+		if s.Fingerprint == "" {
+			return nil, errors.New("Must fingerprint first")
+		}
+		suffix := "." + strconv.Itoa(i)
+		form.Set("fingerprint"+suffix, s.Fingerprint)
+		form.Set("duration"+suffix, s.Duration)
+		fields := map[string]string{
+			"mbid":        s.RecordingID,
+			"track":       s.Title,
+			"artist":      s.Artist,
+			"album":       s.Album,
+			"albumartist": s.AlbumArtist,
+			"fileformat":  s.FileFormat,
+		}
+		for name, value := range map[string]int{"year": s.Year, "trackno": s.TrackNumber, "discno": s.DiscNumber} {
+			if value != 0 {
+				fields[name] = strconv.Itoa(value)
+			}
+		}
+		for name, value := range fields {
+			if value != "" {
+				form.Set(name+suffix, value)
+			}
+		}
+	}
+	response := acoustidSubmissions{}
+	if err := a.request(ctx, "POST", "/submit", form, &response); err != nil {
+		return nil, err
+	}
+	statuses := make([]AcoustidSubmissionStatus, len(submissions))
+	for _, s := range response.Submissions {
+		i, err := strconv.Atoi(s.Index.String())
+		if err != nil || i < 0 || i >= len(statuses) {
+			return nil, errors.New("Invalid submission index in AcoustID response")
+		}
+		statuses[i] = s
+	}
+	return statuses, nil
+}
+
+// SubmissionStatus of previous submissions, by ID.
+func (a *AcousticID) SubmissionStatus(ids []int) ([]AcoustidSubmissionStatus, error) {
+	return a.SubmissionStatusContext(context.Background(), ids)
+}
+
+// SubmissionStatusContext of previous submissions, until the context is done.
+func (a *AcousticID) SubmissionStatusContext(ctx context.Context, ids []int) ([]AcoustidSubmissionStatus, error) {
+	form := url.Values{}
+	form.Set("format", "json")
+	form.Set("client", a.APIKey)
+	form.Set("clientversion", acoustidClientVersion)
+	for _, id := range ids {
+		form.Add("id", strconv.Itoa(id))
+	}
+	response := acoustidSubmissions{}
+	if err := a.request(withCaching(ctx, false), "GET", "/submission_status", form, &response); err != nil {
+		return nil, err
+	}
+	return response.Submissions, nil
+}
+
+// WaitForSubmissions polls their status every interval, until all are
+// imported or the timeout expires. The last statuses are returned either way.
+func (a *AcousticID) WaitForSubmissions(statuses []AcoustidSubmissionStatus, interval, timeout time.Duration) ([]AcoustidSubmissionStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return a.WaitForSubmissionsContext(ctx, statuses, interval)
+}
+
+// WaitForSubmissionsContext polls their status every interval, until all are
+// imported or the context is done. The last statuses are returned either way.
+func (a *AcousticID) WaitForSubmissionsContext(ctx context.Context, statuses []AcoustidSubmissionStatus, interval time.Duration) ([]AcoustidSubmissionStatus, error) {
+	statuses = append([]AcoustidSubmissionStatus{}, statuses...)
+	for {
+		pending := []int{}
+		for _, s := range statuses {
+			if !s.Imported() {
+				pending = append(pending, s.ID)
+			}
+		}
+		if len(pending) == 0 {
+			return statuses, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(interval).After(deadline) {
+			return statuses, errors.New("Timeout waiting for AcoustID to import submissions")
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return statuses, err
+		}
+		updates, err := a.SubmissionStatusContext(ctx, pending)
+		if err != nil {
+			return statuses, err
+		}
+		for _, u := range updates {
+			for i := range statuses {
+				if statuses[i].ID == u.ID {
+					u.Index = statuses[i].Index
+					statuses[i] = u
+				}
+			}
+		}
+	}
+}
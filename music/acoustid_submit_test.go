@@ -0,0 +1,74 @@
+package music
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcoustidSubmit(t *testing.T) {
+	fmt.Println("+ Testing AcoustID submissions...")
+	check := assert.New(t)
+
+	server := newTestAcoustidServer(t)
+	defer server.Close()
+	defer func(size int) { AcoustidBatchSize = size }(AcoustidBatchSize)
+	AcoustidBatchSize = 2
+
+	tracks := []LocalTrack{
+		{Path: "01.flac", Title: "One", Artist: "Artist", Album: "Album", TrackNumber: 1, Year: 2001, RecordingID: "rec-1"},
+		{Path: "02.flac", Title: "Two", Artist: "Artist", Album: "Album", TrackNumber: 2},
+		{Path: "03.flac", Title: "Three", Artist: "Artist", Album: "Album", TrackNumber: 3, RecordingID: "rec-3"},
+	}
+	submissions := []AcoustidSubmission{}
+	for i, track := range tracks {
+		submissions = append(submissions, NewAcoustidSubmission(track, AcoustidFingerprint{fmt.Sprintf("fp%d", i), "180"}))
+	}
+	check.Equal("FLAC", submissions[0].FileFormat)
+
+	a := NewAcoustid("key")
+	a.Options.BaseURL = server.URL
+	_, err := a.Submit(submissions)
+	check.NotNil(err, "User key is required")
+	a.UserKey = "wrong"
+	_, err = a.Submit(submissions)
+	require.NotNil(t, err)
+	check.Equal("Acoustid Error: invalid user API key", err.Error())
+
+	a.UserKey = "user-key"
+	statuses, err := a.Submit(submissions)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(statuses))
+	require.Equal(t, 2, len(server.submissions))
+	form := server.submissions[0]
+	check.Equal("fp0", form.Get("fingerprint.0"))
+	check.Equal("180", form.Get("duration.0"))
+	check.Equal("rec-1", form.Get("mbid.0"))
+	check.Equal("2001", form.Get("year.0"))
+	check.Equal("1", form.Get("trackno.0"))
+	check.Equal("FLAC", form.Get("fileformat.0"))
+	check.Equal("", form.Get("mbid.1"))
+	check.Equal("Two", form.Get("track.1"))
+	check.Equal("", form.Get("discno.1"))
+	check.Equal("rec-3", server.submissions[1].Get("mbid.0"))
+	check.Equal(100, statuses[0].ID)
+	check.Equal(200, statuses[2].ID)
+	check.False(statuses[0].Imported())
+
+	_, err = a.WaitForSubmissions(statuses, 10*time.Millisecond, 15*time.Millisecond)
+	check.NotNil(err, "Still pending after the first poll")
+	check.False(statuses[0].Imported(), "Statuses are not modified in place")
+	imported, err := a.WaitForSubmissions(statuses, 10*time.Millisecond, time.Second)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(imported))
+	for i, s := range imported {
+		check.True(s.Imported())
+		check.Equal(statuses[i].ID, s.ID)
+		check.Equal(fmt.Sprintf("acoustid-%d", s.ID), s.Result.ID)
+	}
+	check.Equal("1", imported[1].Index.String())
+	check.Equal(2, server.polls)
+}
@@ -1,9 +1,14 @@
 package music
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,17 +61,21 @@ func TestAcoustid(t *testing.T) {
 	fmt.Println("+ Testing Acoustid...")
 	check := assert.New(t)
 
-	// get api key from env
+	options := testFixtureOptions(t)
+	// get api key from env, only needed to record fixtures
 	key := os.Getenv("ACOUSTID_API_KEY")
-	require.NotEqual(t, 0, len(key), "Cannot get Acoustid API key")
-
-	for _, t := range testTracks {
-		fmt.Println("Testing with " + t.path)
+	if *recordFixtures {
+		require.NotEqual(t, 0, len(key), "Cannot get Acoustid API key")
+	} else if key == "" {
+		key = "replay"
+	}
+	for _, track := range testTracks {
+		fmt.Println("Testing with " + track.path)
 		a := NewAcoustid(key)
-		err := a.CalculateFingerprint(t.path)
-		check.Nil(err)
-		check.Equal(t.duration, a.Duration, "Unexpected track duration")
-		check.Equal(t.fingerprint, a.Fingerprint, "Unexpected track fingerprint")
+		a.Options = options
+		testFixtureFingerprint(t, a, track.path)
+		check.Equal(track.duration, a.Duration, "Unexpected track duration")
+		check.Equal(track.fingerprint, a.Fingerprint, "Unexpected track fingerprint")
 
 		results, err := a.LookUp()
 		check.Nil(err)
@@ -74,14 +83,83 @@ func TestAcoustid(t *testing.T) {
 		check.Equal("ok", results.Status)
 		// TODO case where no results
 		if len(results.Results) != 0 {
-			check.Equal(t.artist, results.Results[0].Recordings[0].Artists[0].Name)
-			check.Equal(t.title, results.Results[0].Recordings[0].Title)
-			check.Equal(t.albumTitle, results.Results[0].Recordings[0].Releases[0].Title)
-			check.Equal(t.position, results.Results[0].Recordings[0].Releases[0].Mediums[0].Tracks[0].Position)
-			check.Equal(t.title, results.Results[0].Recordings[0].Releases[0].Mediums[0].Tracks[0].Title)
-			check.Equal(t.mbReleaseID, results.Results[0].Recordings[0].Releases[0].ID)
+			check.Equal(track.artist, results.Results[0].Recordings[0].Artists[0].Name)
+			check.Equal(track.title, results.Results[0].Recordings[0].Title)
+			check.Equal(track.albumTitle, results.Results[0].Recordings[0].Releases[0].Title)
+			check.Equal(track.position, results.Results[0].Recordings[0].Releases[0].Mediums[0].Tracks[0].Position)
+			check.Equal(track.title, results.Results[0].Recordings[0].Releases[0].Mediums[0].Tracks[0].Title)
+			check.Equal(track.mbReleaseID, results.Results[0].Recordings[0].Releases[0].ID)
 		}
 
 	}
 
 }
+
+func TestFpcalc(t *testing.T) {
+	fmt.Println("+ Testing fpcalc output...")
+	check := assert.New(t)
+
+	// fingerprints computed by fpcalc decode to raw fingerprints that encode back to the same
+	for _, track := range testTracks {
+		raw, algorithm, err := DecodeFingerprint(track.fingerprint)
+		require.Nil(t, err)
+		check.Equal(chromaprintAlgorithm, algorithm)
+		check.NotEqual(0, len(raw))
+		check.Equal(track.fingerprint, EncodeFingerprint(raw))
+	}
+
+	raw, duration, err := parseFpcalc([]byte(`{"duration": 113.53, "fingerprint": "` + testTracks[1].fingerprint + `"}`))
+	require.Nil(t, err)
+	check.Equal(113, duration)
+	check.Equal(testTracks[1].fingerprint, EncodeFingerprint(raw))
+	raw, duration, err = parseFpcalc([]byte(`{"duration": 138.00, "fingerprint": [1, 4294967295, -1]}`))
+	require.Nil(t, err)
+	check.Equal(138, duration)
+	check.Equal([]uint32{1, 0xFFFFFFFF, 0xFFFFFFFF}, raw)
+	_, _, err = parseFpcalc([]byte(`{"duration": 138.00}`))
+	check.NotNil(err)
+	_, _, err = parseFpcalc([]byte(`DURATION=138`))
+	check.NotNil(err)
+
+	// a fake fpcalc, checking its arguments
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	script := `#!/bin/sh
+[ "$*" = "-json -raw -length 30 -algorithm 4 track.mp3" ] || exit 1
+echo '{"duration": 12.5, "fingerprint": [7, 8, 9]}'
+`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fpcalc"), []byte(script), 0755))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	a := NewAcoustid("key")
+	a.Length, a.Algorithm = 30, 4
+	require.Nil(t, a.CalculateFingerprint("track.mp3"))
+	check.Equal("12", a.Duration)
+	check.Equal([]uint32{7, 8, 9}, a.RawFingerprint)
+	check.True(strings.HasPrefix(a.Fingerprint, "AwAAA"))
+	decoded, algorithm, err := DecodeFingerprint(a.Fingerprint)
+	require.Nil(t, err)
+	check.Equal(3, algorithm)
+	check.Equal([]uint32{7, 8, 9}, decoded)
+
+	a.Length = 10
+	check.NotNil(a.CalculateFingerprint("track.mp3"))
+
+	// a stuck fpcalc is killed
+	script = `#!/bin/sh
+exec sleep 10
+`
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fpcalc"), []byte(script), 0755))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	check.Equal(context.DeadlineExceeded, a.CalculateFingerprintContext(ctx, "track.mp3"))
+	check.True(time.Since(start) < 5*time.Second)
+	defer func(timeout time.Duration) { FpcalcTimeout = timeout }(FpcalcTimeout)
+	FpcalcTimeout = 50 * time.Millisecond
+	start = time.Now()
+	check.NotNil(a.CalculateFingerprint("track.mp3"))
+	check.True(time.Since(start) < 5*time.Second)
+}
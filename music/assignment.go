@@ -0,0 +1,190 @@
+package music
+
+import (
+	"math"
+)
+
+// Assignment cost components, in addition to durations and track titles.
+const (
+	TrackNumberComponent = "track number"
+	RecordingComponent   = "recording"
+)
+
+// unassignedCost of leaving a file or a track out of the assignment.
+// Pairs costing more than twice as much are never made.
+const unassignedCost = 0.4
+
+// AssignmentWeights of each component in the cost of pairing a file with a track.
+var AssignmentWeights = map[string]float64{
+	RecordingComponent:   8,
+	DurationComponent:    4,
+	TrackTitleComponent:  2,
+	TrackNumberComponent: 2,
+}
+
+// AssignedTrack is a local file paired with a track of the release.
+type AssignedTrack struct {
+	Local  LocalTrack
+	Medium int
+	Track  Track
+	Cost   float64
+}
+
+// Assignment of local files to the tracks of a release.
+type Assignment struct {
+	Release        *Release
+	Pairs          []AssignedTrack
+	UnmatchedFiles []LocalTrack
+	MissingTracks  []Track
+	Cost           float64
+}
+
+// releaseTrack is a track with its medium and absolute position in the release.
+type releaseTrack struct {
+	Track
+	medium, index int
+}
+
+// AssignTracks to local files, minimizing the total cost of the pairs
+// whatever the file names and tags.
+// Pairs are sorted in release order.
+func AssignTracks(tracks []LocalTrack, r *Release) Assignment {
+	a := Assignment{Release: r, Pairs: []AssignedTrack{}, UnmatchedFiles: []LocalTrack{}, MissingTracks: []Track{}}
+	releaseTracks := []releaseTrack{}
+	for _, m := range r.Media {
+		for _, t := range m.Tracks {
+			releaseTracks = append(releaseTracks, releaseTrack{Track: t, medium: m.Position, index: len(releaseTracks) + 1})
+		}
+	}
+	singleMedium := len(r.Media) == 1
+
+	// square matrix: files and dummy files as rows, tracks and dummy tracks
+	// as columns. Assigning a file to a dummy track leaves it unmatched.
+	size := len(tracks) + len(releaseTracks)
+	cost := make([][]float64, size)
+	for i := range cost {
+		cost[i] = make([]float64, size)
+		for j := range cost[i] {
+			switch {
+			case i < len(tracks) && j < len(releaseTracks):
+				cost[i][j] = assignmentCost(tracks[i], releaseTracks[j], singleMedium)
+			case i < len(tracks) || j < len(releaseTracks):
+				cost[i][j] = unassignedCost
+			}
+		}
+	}
+
+	assignedTracks := make([]bool, len(releaseTracks))
+	fileForTrack := make([]int, len(releaseTracks))
+	for i, j := range hungarian(cost) {
+		switch {
+		case i >= len(tracks):
+			continue
+		case j >= len(releaseTracks):
+			a.UnmatchedFiles = append(a.UnmatchedFiles, tracks[i])
+			a.Cost += unassignedCost
+		default:
+			assignedTracks[j] = true
+			fileForTrack[j] = i
+		}
+	}
+	for j, t := range releaseTracks {
+		if !assignedTracks[j] {
+			a.MissingTracks = append(a.MissingTracks, t.Track)
+			a.Cost += unassignedCost
+			continue
+		}
+		local := tracks[fileForTrack[j]]
+		pair := AssignedTrack{Local: local, Medium: t.medium, Track: t.Track, Cost: cost[fileForTrack[j]][j]}
+		a.Pairs = append(a.Pairs, pair)
+		a.Cost += pair.Cost
+	}
+	return a
+}
+
+// assignmentCost of pairing a file with a track, between 0 and 1.
+// Components that cannot be evaluated are left out; if none can, the cost is 1.
+func assignmentCost(local LocalTrack, t releaseTrack, singleMedium bool) float64 {
+	components := map[string]float64{}
+	if d, ok := durationDistance(local.Duration, t.Length); ok {
+		components[DurationComponent] = d
+	}
+	if local.Title != "" && t.Title != "" {
+		components[TrackTitleComponent] = 1 - stringSimilarity(local.Title, t.Title)
+	}
+	if local.TrackNumber != 0 {
+		components[TrackNumberComponent] = 1
+		sameMedium := local.DiscNumber == t.medium || (local.DiscNumber == 0 && singleMedium)
+		if (sameMedium && local.TrackNumber == t.Position) || (local.DiscNumber == 0 && local.TrackNumber == t.index) {
+			components[TrackNumberComponent] = 0
+		}
+	}
+	if local.RecordingID != "" && t.RecordingID != "" {
+		components[RecordingComponent] = 1
+		if local.RecordingID == t.RecordingID {
+			components[RecordingComponent] = 0
+		}
+	}
+
+	total, weights := 0.0, 0.0
+	for component, distance := range components {
+		total += distance * AssignmentWeights[component]
+		weights += AssignmentWeights[component]
+	}
+	if weights == 0 {
+		return 1
+	}
+	return total / weights
+}
+
+// hungarian algorithm for the minimum cost assignment on a square matrix.
+// It returns the column assigned to each row.
+func hungarian(cost [][]float64) []int {
+	n := len(cost)
+	// potentials and matching are 1-based, index 0 is a sentinel.
+	u, v := make([]float64, n+1), make([]float64, n+1)
+	rowForColumn, way := make([]int, n+1), make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		rowForColumn[0] = i
+		column := 0
+		minimum := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minimum {
+			minimum[j] = math.Inf(1)
+		}
+		for rowForColumn[column] != 0 {
+			used[column] = true
+			row, delta, next := rowForColumn[column], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if c := cost[row-1][j-1] - u[row] - v[j]; c < minimum[j] {
+					minimum[j], way[j] = c, column
+				}
+				if minimum[j] < delta {
+					delta, next = minimum[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[rowForColumn[j]] += delta
+					v[j] -= delta
+				} else {
+					minimum[j] -= delta
+				}
+			}
+			column = next
+		}
+		for column != 0 {
+			previous := way[column]
+			rowForColumn[column] = rowForColumn[previous]
+			column = previous
+		}
+	}
+	columnForRow := make([]int, n)
+	for j := 1; j <= n; j++ {
+		columnForRow[rowForColumn[j]-1] = j - 1
+	}
+	return columnForRow
+}
@@ -0,0 +1,82 @@
+package music
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHungarian(t *testing.T) {
+	fmt.Println("+ Testing Hungarian algorithm...")
+	check := assert.New(t)
+
+	check.Equal([]int{}, hungarian([][]float64{}))
+	check.Equal([]int{0}, hungarian([][]float64{{5}}))
+	check.Equal([]int{1, 0, 2}, hungarian([][]float64{
+		{4, 1, 3},
+		{2, 0, 5},
+		{3, 2, 2},
+	}))
+	check.Equal([]int{2, 0, 1, 3}, hungarian([][]float64{
+		{9, 11, 3, 8},
+		{2, 14, 6, 11},
+		{8, 1, 7, 12},
+		{7, 10, 9, 2},
+	}))
+}
+
+func TestAssignTracks(t *testing.T) {
+	fmt.Println("+ Testing track assignment...")
+	check := assert.New(t)
+
+	r := &Release{
+		Title: "The Fragile",
+		Media: []Medium{
+			{Position: 1, Tracks: []Track{
+				{Position: 1, RecordingID: "r1", Title: "Somewhat Damaged", Length: 271 * time.Second},
+				{Position: 2, RecordingID: "r2", Title: "The Day the World Went Away", Length: 273 * time.Second},
+			}},
+			{Position: 2, Tracks: []Track{
+				{Position: 1, RecordingID: "r3", Title: "The Wretched", Length: 325 * time.Second},
+				{Position: 2, RecordingID: "r4", Title: "We're in This Together", Length: 436 * time.Second},
+			}},
+		},
+	}
+
+	// shuffled, garbage names, partially tagged, one extra file and one missing track
+	files := []LocalTrack{
+		{Path: "x.flac", Duration: 324 * time.Second},
+		{Path: "track01.flac", Duration: 272 * time.Second, Title: "Somewhat damaged", TrackNumber: 1, DiscNumber: 1},
+		{Path: "y.flac", Duration: 120 * time.Second, Title: "Bonus interview"},
+		{Path: "z.flac", Duration: 250 * time.Second, RecordingID: "r2"},
+	}
+
+	a := AssignTracks(files, r)
+	require.Equal(t, 3, len(a.Pairs))
+	check.Equal("track01.flac", a.Pairs[0].Local.Path)
+	check.Equal("r1", a.Pairs[0].Track.RecordingID)
+	check.Equal(1, a.Pairs[0].Medium)
+	check.Equal("z.flac", a.Pairs[1].Local.Path)
+	check.Equal("r2", a.Pairs[1].Track.RecordingID)
+	check.Equal("x.flac", a.Pairs[2].Local.Path)
+	check.Equal("r3", a.Pairs[2].Track.RecordingID)
+	check.Equal(2, a.Pairs[2].Medium)
+	require.Equal(t, 1, len(a.UnmatchedFiles))
+	check.Equal("y.flac", a.UnmatchedFiles[0].Path)
+	require.Equal(t, 1, len(a.MissingTracks))
+	check.Equal("r4", a.MissingTracks[0].RecordingID)
+
+	// absolute track numbers across discs
+	check.Equal(0.0, assignmentCost(LocalTrack{TrackNumber: 3}, releaseTrack{Track: r.Media[1].Tracks[0], medium: 2, index: 3}, false))
+	check.Equal(1.0, assignmentCost(LocalTrack{TrackNumber: 1}, releaseTrack{Track: r.Media[1].Tracks[0], medium: 2, index: 3}, false))
+	check.Equal(1.0, assignmentCost(LocalTrack{}, releaseTrack{Track: r.Media[1].Tracks[0], medium: 2, index: 3}, false))
+
+	// nothing to assign
+	a = AssignTracks([]LocalTrack{}, r)
+	check.Equal(0, len(a.Pairs))
+	check.Equal(4, len(a.MissingTracks))
+	check.InDelta(4*unassignedCost, a.Cost, 0.0001)
+}
@@ -0,0 +1,255 @@
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultCacheTTL = 24 * time.Hour
+	// cacheStoredHeader records when a cached response was stored or last revalidated.
+	cacheStoredHeader = "X-Aubergine-Stored"
+)
+
+// HTTPCache stores provider responses, keyed by request.
+type HTTPCache interface {
+	// Get a stored response, if any.
+	Get(key string) ([]byte, bool)
+	// Set the response stored for a key.
+	Set(key string, response []byte) error
+	// Delete the response stored for a key.
+	Delete(key string) error
+}
+
+// UseCache for the requests of new MusicBrainz, Discogs and AcoustID releases,
+// searches and lookups, with responses considered fresh for ttl (24h if 0).
+func UseCache(cache HTTPCache, ttl time.Duration) {
+	for _, o := range []*ProviderOptions{&MusicBrainzOptions, &DiscogsOptions, &AcoustidOptions} {
+		o.Cache, o.CacheTTL = cache, ttl
+	}
+}
+
+// FileCache stores responses in a directory, one file per request.
+type FileCache struct {
+	Dir string
+}
+
+// NewFileCache in a directory, created if necessary.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCache{Dir: dir}, nil
+}
+
+// path of the file storing the response for a key.
+func (c *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.Dir, name[:2], name)
+}
+
+// Get a stored response, if any.
+func (c *FileCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set the response stored for a key.
+// The file is replaced atomically, so that concurrent readers never see partial responses.
+func (c *FileCache) Set(key string, response []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(response); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Delete the response stored for a key.
+func (c *FileCache) Delete(key string) error {
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type cachingKey struct{}
+
+// withCaching overrides whether requests made with the context are cached.
+// By default, only GET requests are.
+func withCaching(ctx context.Context, cached bool) context.Context {
+	return context.WithValue(ctx, cachingKey{}, cached)
+}
+
+// cacheTransport serves fresh responses from the cache without any request,
+// revalidates stale responses with their ETag or Last-Modified headers,
+// and falls back to stale responses if the service cannot be reached or fails.
+// Responses are stored whatever their Cache-Control headers: ttl is the only policy.
+type cacheTransport struct {
+	cache     HTTPCache
+	ttl       time.Duration
+	transport http.RoundTripper
+}
+
+// cacheKey of a request: its method, URL, and body.
+// Headers, which can include one-time authentication, are ignored.
+func cacheKey(req *http.Request) (string, error) {
+	key := req.Method + " " + req.URL.String()
+	if req.Body == nil || req.Body == http.NoBody {
+		return key, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return key + "\n" + string(body), nil
+}
+
+// cachedResponse to a request, and when it was stored.
+func (t *cacheTransport) cachedResponse(req *http.Request, key string) (*http.Response, time.Time, bool) {
+	data, ok := t.cache.Get(key)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	stored, err := time.Parse(time.RFC3339Nano, resp.Header.Get(cacheStoredHeader))
+	if err != nil {
+		resp.Body.Close()
+		return nil, time.Time{}, false
+	}
+	resp.Header.Del(cacheStoredHeader)
+	return resp, stored, true
+}
+
+// store a response, returning it with a body that can still be read.
+func (t *cacheTransport) store(key string, resp *http.Response) (*http.Response, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	stored := *resp
+	stored.Header = resp.Header.Clone()
+	stored.Header.Set(cacheStoredHeader, time.Now().Format(time.RFC3339Nano))
+	stored.Body = ioutil.NopCloser(bytes.NewReader(body))
+	data, err := httputil.DumpResponse(&stored, true)
+	if err == nil {
+		err = t.cache.Set(key, data)
+	}
+	if err != nil {
+		// the response is still usable, only not cached
+		fmt.Println("Could not cache response: " + err.Error())
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// RoundTrip a request, through the cache if possible.
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cached, ok := req.Context().Value(cachingKey{}).(bool)
+	if !ok {
+		cached = req.Method == "GET"
+	}
+	if !cached {
+		return t.transport.RoundTrip(req)
+	}
+	key, err := cacheKey(req)
+	if err != nil {
+		return nil, err
+	}
+	ttl := t.ttl
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	resp, stored, ok := t.cachedResponse(req, key)
+	if ok && time.Since(stored) < ttl {
+		return resp, nil
+	}
+
+	// revalidate stale responses, without modifying the original request
+	conditional := req
+	if ok && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") {
+		conditional = req.Clone(req.Context())
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			conditional.Header.Set("If-None-Match", etag)
+		}
+		if modified := resp.Header.Get("Last-Modified"); modified != "" {
+			conditional.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	fresh, err := t.transport.RoundTrip(conditional)
+	if err != nil {
+		if ok && req.Context().Err() == nil {
+			return resp, nil
+		}
+		return nil, err
+	}
+	switch {
+	case ok && fresh.StatusCode == http.StatusNotModified:
+		fresh.Body.Close()
+		for name, values := range fresh.Header {
+			if name != "Content-Length" {
+				resp.Header[name] = values
+			}
+		}
+		return t.store(key, resp)
+	case fresh.StatusCode == http.StatusOK:
+		if ok {
+			resp.Body.Close()
+		}
+		return t.store(key, fresh)
+	case ok && fresh.StatusCode >= http.StatusInternalServerError:
+		// the service is unavailable, as if it could not be reached
+		fresh.Body.Close()
+		return resp, nil
+	}
+	if ok {
+		resp.Body.Close()
+	}
+	return fresh, nil
+}
+
+// rateLimitedTransport waits for its rate limiter before each request.
+type rateLimitedTransport struct {
+	limiter   *RateLimiter
+	transport http.RoundTripper
+}
+
+// RoundTrip a request once the rate limiter allows it.
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.WaitContext(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.transport.RoundTrip(req)
+}
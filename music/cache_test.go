@@ -0,0 +1,210 @@
+package music
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCache(t *testing.T) {
+	fmt.Println("+ Testing file cache...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	c, err := NewFileCache(filepath.Join(dir, "cache"))
+	require.Nil(t, err)
+
+	_, ok := c.Get("GET http://example.org")
+	check.False(ok)
+	require.Nil(t, c.Set("GET http://example.org", []byte("one")))
+	require.Nil(t, c.Set("GET http://example.org", []byte("two")))
+	data, ok := c.Get("GET http://example.org")
+	check.True(ok)
+	check.Equal("two", string(data))
+	_, ok = c.Get("POST http://example.org")
+	check.False(ok)
+	files, err := filepath.Glob(filepath.Join(dir, "cache", "*", "*"))
+	require.Nil(t, err)
+	check.Equal(1, len(files), "No temporary files left")
+
+	require.Nil(t, c.Delete("GET http://example.org"))
+	_, ok = c.Get("GET http://example.org")
+	check.False(ok)
+	check.Nil(c.Delete("GET http://example.org"), "Deleting a missing entry is not an error")
+}
+
+func TestProviderCache(t *testing.T) {
+	fmt.Println("+ Testing provider cache...")
+	check := assert.New(t)
+
+	defer MusicBrainzRateLimiter.SetRate(time.Second, 1)
+	MusicBrainzRateLimiter.SetRate(time.Millisecond, 1)
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := NewFileCache(dir)
+	require.Nil(t, err)
+
+	modified := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	requests := map[string]int{}
+	notModified := map[string]int{}
+	unavailable := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if unavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b":
+			w.Header().Set("ETag", `"fragile"`)
+			if r.Header.Get("If-None-Match") == `"fragile"` {
+				notModified[r.URL.Path]++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprint(w, testMusicBrainzReleaseJSON)
+		case "/releases/249504":
+			w.Header().Set("Last-Modified", modified)
+			if r.Header.Get("If-Modified-Since") == modified {
+				notModified[r.URL.Path]++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprint(w, testDiscogsReleaseJSON)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(mb, d ProviderOptions) { MusicBrainzOptions, DiscogsOptions = mb, d }(MusicBrainzOptions, DiscogsOptions)
+	UseCache(cache, 0)
+	MusicBrainzOptions.BaseURL = server.URL + "/ws/2"
+	DiscogsOptions.BaseURL = server.URL
+
+	// fresh responses are served from the cache, without waiting for the rate limiter
+	mb := NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, mb.GetInfo())
+	check.Equal("The Fragile", mb.Info.Title)
+	MusicBrainzRateLimiter.SetRate(time.Hour, 1)
+	start := time.Now()
+	mb = NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, mb.GetInfo())
+	check.Equal("The Fragile", mb.Info.Title)
+	check.Equal(1, requests["/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b"])
+	check.True(time.Since(start) < time.Second)
+	MusicBrainzRateLimiter.SetRate(time.Millisecond, 1)
+
+	// errors are not cached
+	check.NotNil(NewMusicBrainzRelease("missing").GetInfo())
+	check.NotNil(NewMusicBrainzRelease("missing").GetInfo())
+	check.Equal(2, requests["/ws/2/release/missing"])
+
+	// stale responses are revalidated
+	mb = NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	mb.Options.CacheTTL = -1
+	require.Nil(t, mb.GetInfo())
+	check.Equal("The Fragile", mb.Info.Title)
+	check.Equal(2, requests["/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b"])
+	check.Equal(1, notModified["/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b"])
+
+	d := NewDiscogsRelease("token", "secret")
+	r, err := d.ReleaseByID("249504")
+	require.Nil(t, err)
+	check.Equal("Never Gonna Give You Up", r.Title)
+	d.Options.CacheTTL = -1
+	r, err = d.ReleaseByID("249504")
+	require.Nil(t, err)
+	check.Equal("Never Gonna Give You Up", r.Title)
+	check.Equal(2, requests["/releases/249504"])
+	check.Equal(1, notModified["/releases/249504"])
+	check.Equal(0, d.Details.ID, "Shared providers are not modified")
+	shared := NewMusicBrainzRelease("")
+	r, err = shared.ReleaseByID("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, err)
+	check.Equal("The Fragile", r.Title)
+	check.Equal("", shared.ID)
+	check.Equal("", shared.Info.Title)
+
+	// stale responses are used if the service fails
+	unavailable = true
+	mb = NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	mb.Options.CacheTTL = -1
+	require.Nil(t, mb.GetInfo())
+	check.Equal("The Fragile", mb.Info.Title)
+	check.Equal(3, requests["/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b"])
+	_, err = d.ReleaseByID("249504")
+	check.Nil(err)
+	unavailable = false
+
+	// or if it cannot be reached
+	server.Close()
+	mb = NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	mb.Options.CacheTTL = -1
+	require.Nil(t, mb.GetInfo())
+	check.Equal("The Fragile", mb.Info.Title)
+	_, err = d.ReleaseByID("249504")
+	check.Nil(err)
+	check.NotNil(NewMusicBrainzRelease("missing").GetInfo())
+}
+
+func TestAcoustidCache(t *testing.T) {
+	fmt.Println("+ Testing AcoustID cache...")
+	check := assert.New(t)
+
+	server := newTestAcoustidServer(t)
+	defer server.Close()
+	server.positions["fp0"] = 1
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := NewFileCache(dir)
+	require.Nil(t, err)
+
+	a := NewAcoustid("key")
+	a.Options = ProviderOptions{BaseURL: server.URL, Cache: cache}
+	a.UserKey = "user-key"
+	fingerprints := []AcoustidFingerprint{{"fp0", "180"}, {"fp1", "200"}}
+
+	// lookups are cached, although they are sent as forms
+	for i := 0; i < 2; i++ {
+		results, err := a.LookUpBatch(fingerprints)
+		require.Nil(t, err)
+		require.Equal(t, 2, len(results))
+		check.Equal(1, len(results[0].Results))
+	}
+	check.Equal(1, server.requests["/lookup"])
+	a.Fingerprint, a.Duration = "fp0", "180"
+	_, err = a.LookUp()
+	require.Nil(t, err)
+	check.Equal(2, server.requests["/lookup"], "Single lookups are different requests")
+	_, err = a.LookUp()
+	require.Nil(t, err)
+	check.Equal(2, server.requests["/lookup"])
+
+	// submissions and their status are not
+	submissions := []AcoustidSubmission{NewAcoustidSubmission(LocalTrack{Path: "01.flac"}, fingerprints[0])}
+	for i := 0; i < 2; i++ {
+		_, err = a.Submit(submissions)
+		require.Nil(t, err)
+	}
+	check.Equal(2, server.requests["/submit"])
+	statuses, err := a.Submit(submissions)
+	require.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = a.SubmissionStatus([]int{statuses[0].ID})
+		require.Nil(t, err)
+	}
+	check.Equal(2, server.requests["/submission_status"])
+}
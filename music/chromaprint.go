@@ -0,0 +1,597 @@
+package music
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math"
+	"math/cmplx"
+)
+
+// Chromaprint configuration, for its default algorithm (TEST2).
+const (
+	chromaprintAlgorithm  = 1
+	chromaprintSampleRate = 11025
+	chromaprintFrameSize  = 4096
+	chromaprintHop        = chromaprintFrameSize / 3 // 1365
+	chromaprintMinFreq    = 28
+	chromaprintMaxFreq    = 3520
+	chromaprintBands      = 12
+	chromaprintBufferSize = 32768
+)
+
+// Resampler configuration, as used by chromaprint.
+const (
+	resampleFilterLength = 16
+	resamplePhaseShift   = 8
+	resampleCutoff       = 0.8
+	resampleFilterShift  = 15
+	resampleWindowType   = 9 // Kaiser window beta
+)
+
+// ChromaprintMaxDuration, in seconds, of audio fingerprinted from the start of each file, as fpcalc does.
+var ChromaprintMaxDuration = 120
+
+var chromaFilterCoefficients = []float64{0.25, 0.75, 1.0, 0.75, 0.25}
+
+type chromaprintClassifier struct {
+	filter, y, height, width int
+	t0, t1, t2               float64
+}
+
+var chromaprintClassifiers = []chromaprintClassifier{
+	{0, 4, 3, 15, 1.98215, 2.35817, 2.63523},
+	{4, 4, 6, 15, -1.03809, -0.651211, -0.282167},
+	{1, 0, 4, 16, -0.298702, 0.119262, 0.558497},
+	{3, 8, 2, 12, -0.105439, 0.0153946, 0.135898},
+	{3, 4, 4, 8, -0.142891, 0.0258736, 0.200632},
+	{4, 0, 3, 5, -0.826319, -0.590612, -0.368214},
+	{1, 2, 2, 9, -0.557409, -0.233035, 0.0534525},
+	{2, 7, 3, 4, -0.0646826, 0.00620476, 0.0784847},
+	{2, 6, 2, 16, -0.192387, -0.029699, 0.215855},
+	{2, 1, 3, 2, -0.0397818, -0.00568076, 0.0292026},
+	{5, 10, 1, 15, -0.53823, -0.369934, -0.190235},
+	{3, 6, 2, 10, -0.124877, 0.0296483, 0.139239},
+	{2, 1, 1, 14, -0.101475, 0.0225617, 0.231971},
+	{3, 5, 6, 4, -0.0799915, -0.00729616, 0.063262},
+	{1, 9, 2, 12, -0.272556, 0.019424, 0.302559},
+	{3, 4, 2, 14, -0.164292, -0.0321188, 0.08463},
+}
+
+// chromaprintMaxFilterWidth among classifiers: a subfingerprint needs that many chroma frames.
+const chromaprintMaxFilterWidth = 16
+
+// Chromaprinter computes chromaprint fingerprints from interleaved 16-bit samples.
+type Chromaprinter struct {
+	channels  int
+	resampler *resampler
+	buffer    []int16 // mono, at the input sample rate
+	samples   []int16 // mono, at 11025 Hz, not yet part of an FFT frame
+	fft       *chromaprintFFT
+	notes     []int
+	chroma    [][]float64 // last chroma vectors, for the chroma filter
+	image     [][]float64 // integral image of the normalized chroma features
+	raw       []uint32
+}
+
+// NewChromaprinter for audio with a given sample rate and number of channels.
+func NewChromaprinter(sampleRate, channels int) *Chromaprinter {
+	c := &Chromaprinter{channels: channels, fft: newChromaprintFFT(chromaprintFrameSize)}
+	if channels < 1 {
+		c.channels = 1
+	}
+	if sampleRate != chromaprintSampleRate {
+		c.resampler = newResampler(chromaprintSampleRate, sampleRate)
+	}
+	c.notes = make([]int, chromaprintFrameSize)
+	for i := chromaIndex(chromaprintMinFreq); i < chromaIndex(chromaprintMaxFreq); i++ {
+		freq := float64(i) * chromaprintSampleRate / chromaprintFrameSize
+		octave := math.Log(freq/(440.0/16.0)) / math.Log(2.0)
+		c.notes[i] = int(chromaprintBands * (octave - math.Floor(octave)))
+	}
+	return c
+}
+
+func chromaIndex(freq float64) int {
+	return int(math.Floor(chromaprintFrameSize*freq/chromaprintSampleRate + 0.5))
+}
+
+// Feed interleaved samples.
+func (c *Chromaprinter) Feed(samples []int16) {
+	for i := 0; i+c.channels <= len(samples); i += c.channels {
+		sum := 0
+		for _, s := range samples[i : i+c.channels] {
+			sum += int(s)
+		}
+		c.buffer = append(c.buffer, int16(sum/c.channels))
+		if len(c.buffer) == chromaprintBufferSize {
+			c.resample()
+		}
+	}
+}
+
+// Finish processing the remaining samples, and return the raw fingerprint.
+func (c *Chromaprinter) Finish() []uint32 {
+	if len(c.buffer) != 0 {
+		c.resample()
+	}
+	return c.raw
+}
+
+func (c *Chromaprinter) resample() {
+	if c.resampler == nil {
+		c.consume(c.buffer)
+		c.buffer = c.buffer[:0]
+		return
+	}
+	out, consumed := c.resampler.resample(c.buffer)
+	c.consume(out)
+	c.buffer = c.buffer[:copy(c.buffer, c.buffer[consumed:])]
+}
+
+// consume resampled audio, one FFT frame at a time.
+func (c *Chromaprinter) consume(samples []int16) {
+	c.samples = append(c.samples, samples...)
+	offset := 0
+	for ; offset+chromaprintFrameSize <= len(c.samples); offset += chromaprintHop {
+		c.addFrame(c.fft.power(c.samples[offset : offset+chromaprintFrameSize]))
+	}
+	c.samples = c.samples[:copy(c.samples, c.samples[offset:])]
+}
+
+func (c *Chromaprinter) addFrame(power []float64) {
+	features := make([]float64, chromaprintBands)
+	for i := chromaIndex(chromaprintMinFreq); i < chromaIndex(chromaprintMaxFreq); i++ {
+		features[c.notes[i]] += power[i]
+	}
+	c.chroma = append(c.chroma, features)
+	if len(c.chroma) < len(chromaFilterCoefficients) {
+		return
+	}
+	c.chroma = c.chroma[len(c.chroma)-len(chromaFilterCoefficients):]
+
+	filtered := make([]float64, chromaprintBands)
+	for b := range filtered {
+		for j, coefficient := range chromaFilterCoefficients {
+			filtered[b] += c.chroma[j][b] * coefficient
+		}
+	}
+	norm := 0.0
+	for _, v := range filtered {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	for b := range filtered {
+		if norm < 0.01 {
+			filtered[b] = 0
+		} else {
+			filtered[b] /= norm
+		}
+	}
+	c.addFeatures(filtered)
+}
+
+func (c *Chromaprinter) addFeatures(features []float64) {
+	row := make([]float64, chromaprintBands)
+	row[0] = features[0]
+	for b := 1; b < chromaprintBands; b++ {
+		row[b] = row[b-1] + features[b]
+	}
+	if len(c.image) != 0 {
+		previous := c.image[len(c.image)-1]
+		for b := range row {
+			row[b] += previous[b]
+		}
+	}
+	c.image = append(c.image, row)
+	if len(c.image) >= chromaprintMaxFilterWidth {
+		c.raw = append(c.raw, c.subfingerprint(len(c.image)-chromaprintMaxFilterWidth))
+	}
+}
+
+// area of the chroma features in rows [r1, r2) and bands [b1, b2).
+func (c *Chromaprinter) area(r1, b1, r2, b2 int) float64 {
+	if r1 == r2 || b1 == b2 {
+		return 0
+	}
+	value := func(r, b int) float64 {
+		if r < 0 || b < 0 {
+			return 0
+		}
+		return c.image[r][b]
+	}
+	return value(r2-1, b2-1) - value(r1-1, b2-1) - value(r2-1, b1-1) + value(r1-1, b1-1)
+}
+
+func (c *Chromaprinter) subfingerprint(x int) uint32 {
+	grayCode := []uint32{0, 1, 3, 2}
+	bits := uint32(0)
+	for _, cl := range chromaprintClassifiers {
+		value := c.classify(cl, x)
+		quantized := 3
+		switch {
+		case value < cl.t0:
+			quantized = 0
+		case value < cl.t1:
+			quantized = 1
+		case value < cl.t2:
+			quantized = 2
+		}
+		bits = bits<<2 | grayCode[quantized]
+	}
+	return bits
+}
+
+func (c *Chromaprinter) classify(cl chromaprintClassifier, x int) float64 {
+	y, w, h := cl.y, cl.width, cl.height
+	var a, b float64
+	switch cl.filter {
+	case 0:
+		a = c.area(x, y, x+w, y+h)
+	case 1:
+		a = c.area(x, y+h/2, x+w, y+h)
+		b = c.area(x, y, x+w, y+h/2)
+	case 2:
+		a = c.area(x+w/2, y, x+w, y+h)
+		b = c.area(x, y, x+w/2, y+h)
+	case 3:
+		a = c.area(x, y+h/2, x+w/2, y+h) + c.area(x+w/2, y, x+w, y+h/2)
+		b = c.area(x, y, x+w/2, y+h/2) + c.area(x+w/2, y+h/2, x+w, y+h)
+	case 4:
+		a = c.area(x, y+h/3, x+w, y+2*h/3)
+		b = c.area(x, y, x+w, y+h/3) + c.area(x, y+2*h/3, x+w, y+h)
+	case 5:
+		a = c.area(x+w/3, y, x+2*w/3, y+h)
+		b = c.area(x, y, x+w/3, y+h) + c.area(x+2*w/3, y, x+w, y+h)
+	}
+	return math.Log(1+a) - math.Log(1+b)
+}
+
+// EncodeFingerprint compresses a raw fingerprint and encodes it in base64, as fpcalc prints it.
+func EncodeFingerprint(raw []uint32) string {
+	return encodeFingerprint(raw, chromaprintAlgorithm)
+}
+
+func encodeFingerprint(raw []uint32, algorithm byte) string {
+	normal, exceptions := []uint32{}, []uint32{}
+	for i, x := range raw {
+		if i != 0 {
+			x ^= raw[i-1]
+		}
+		last := uint32(0)
+		for bit := uint32(1); x != 0; bit, x = bit+1, x>>1 {
+			if x&1 == 0 {
+				continue
+			}
+			if value := bit - last; value >= 7 {
+				normal = append(normal, 7)
+				exceptions = append(exceptions, value-7)
+			} else {
+				normal = append(normal, value)
+			}
+			last = bit
+		}
+		normal = append(normal, 0)
+	}
+	data := []byte{algorithm, byte(len(raw) >> 16), byte(len(raw) >> 8), byte(len(raw))}
+	data = append(data, packBits(normal, 3)...)
+	data = append(data, packBits(exceptions, 5)...)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// packBits of each value, least significant first.
+func packBits(values []uint32, size uint) []byte {
+	packed := make([]byte, (uint(len(values))*size+7)/8)
+	position := uint(0)
+	for _, v := range values {
+		for i := uint(0); i < size; i++ {
+			if v>>i&1 == 1 {
+				packed[position/8] |= 1 << (position % 8)
+			}
+			position++
+		}
+	}
+	return packed
+}
+
+// DecodeFingerprint as printed by fpcalc, returning the raw fingerprint
+// and the algorithm used to compute it.
+func DecodeFingerprint(fingerprint string) ([]uint32, int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(fingerprint)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < 4 {
+		return nil, 0, errors.New("Invalid fingerprint")
+	}
+	algorithm, length := int(data[0]), int(data[1])<<16|int(data[2])<<8|int(data[3])
+
+	normal, zeros := []uint32{}, 0
+	for position := uint(0); zeros < length; position += 3 {
+		if position+3 > uint(len(data)-4)*8 {
+			return nil, 0, errors.New("Truncated fingerprint")
+		}
+		v := unpackBits(data[4:], position, 3)
+		if v == 0 {
+			zeros++
+		}
+		normal = append(normal, v)
+	}
+	exceptions := data[4+(len(normal)*3+7)/8:]
+	position := uint(0)
+	for i, v := range normal {
+		if v != 7 {
+			continue
+		}
+		if position+5 > uint(len(exceptions))*8 {
+			return nil, 0, errors.New("Truncated fingerprint")
+		}
+		normal[i] += unpackBits(exceptions, position, 5)
+		position += 5
+	}
+
+	raw := make([]uint32, 0, length)
+	x, bit := uint32(0), uint32(0)
+	for _, v := range normal {
+		if v == 0 {
+			if len(raw) != 0 {
+				x ^= raw[len(raw)-1]
+			}
+			raw = append(raw, x)
+			x, bit = 0, 0
+			continue
+		}
+		bit += v
+		if bit > 32 {
+			return nil, 0, errors.New("Invalid fingerprint")
+		}
+		x |= 1 << (bit - 1)
+	}
+	return raw, algorithm, nil
+}
+
+// unpackBits of a value, least significant first, at a bit position.
+func unpackBits(data []byte, position, size uint) uint32 {
+	v := uint32(0)
+	for i := uint(0); i < size; i++ {
+		p := position + i
+		v |= uint32(data[p/8]>>(p%8)&1) << i
+	}
+	return v
+}
+
+// FingerprintFlac computes the chromaprint fingerprint of a FLAC file, and its duration in seconds.
+func FingerprintFlac(path string) (string, int, error) {
+	raw, duration, err := RawFingerprintFlac(path, ChromaprintMaxDuration)
+	if err != nil {
+		return "", 0, err
+	}
+	return EncodeFingerprint(raw), duration, nil
+}
+
+// RawFingerprintFlac computes the raw chromaprint fingerprint of the first
+// seconds of a FLAC file, and its duration in seconds.
+func RawFingerprintFlac(path string, maxDuration int) ([]uint32, int, error) {
+	return RawFingerprintFlacContext(context.Background(), path, maxDuration)
+}
+
+// RawFingerprintFlacContext computes the raw chromaprint fingerprint of a FLAC file,
+// stopping between frames if the context is done.
+func RawFingerprintFlacContext(ctx context.Context, path string, maxDuration int) ([]uint32, int, error) {
+	d, err := OpenFlacDecoder(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer d.Close()
+
+	c := NewChromaprinter(int(d.Info.SampleRate), int(d.Info.Channels))
+	remaining := maxDuration * int(d.Info.SampleRate)
+	shift := int(d.Info.BitsPerSample) - 16
+	for remaining > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		frame, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		length := len(frame.Samples[0])
+		if length > remaining {
+			length = remaining
+		}
+		samples := make([]int16, 0, length*len(frame.Samples))
+		for i := 0; i < length; i++ {
+			for _, channel := range frame.Samples {
+				if shift >= 0 {
+					samples = append(samples, int16(channel[i]>>uint(shift)))
+				} else {
+					samples = append(samples, int16(channel[i]<<uint(-shift)))
+				}
+			}
+		}
+		c.Feed(samples)
+		remaining -= length
+	}
+	return c.Finish(), int(d.Info.Duration().Seconds()), nil
+}
+
+//------------------------
+
+// chromaprintFFT computes power spectrums of Hamming-windowed frames.
+type chromaprintFFT struct {
+	window   []float64
+	twiddle  []complex128
+	buffer   []complex128
+	spectrum []float64
+}
+
+func newChromaprintFFT(size int) *chromaprintFFT {
+	f := &chromaprintFFT{
+		window:   make([]float64, size),
+		twiddle:  make([]complex128, size/2),
+		buffer:   make([]complex128, size),
+		spectrum: make([]float64, size/2+1),
+	}
+	for i := range f.window {
+		f.window[i] = (0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(size-1))) / math.MaxInt16
+	}
+	for i := range f.twiddle {
+		f.twiddle[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(size)))
+	}
+	return f
+}
+
+// power spectrum of a frame, which must have the FFT size.
+func (f *chromaprintFFT) power(frame []int16) []float64 {
+	n := len(f.buffer)
+	// bit reversal permutation
+	for i, j := 0, 0; i < n; i++ {
+		f.buffer[j] = complex(float64(frame[i])*f.window[i], 0)
+		for bit := n >> 1; bit > 0; bit >>= 1 {
+			j ^= bit
+			if j&bit != 0 {
+				break
+			}
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := n / size
+		for start := 0; start < n; start += size {
+			for k := 0; k < size/2; k++ {
+				t := f.twiddle[k*step] * f.buffer[start+k+size/2]
+				f.buffer[start+k+size/2] = f.buffer[start+k] - t
+				f.buffer[start+k] += t
+			}
+		}
+	}
+	for i := range f.spectrum {
+		f.spectrum[i] = real(f.buffer[i])*real(f.buffer[i]) + imag(f.buffer[i])*imag(f.buffer[i])
+	}
+	return f.spectrum
+}
+
+//------------------------
+
+// resampler is a polyphase windowed sinc resampler, producing the same
+// output as the one chromaprint uses.
+type resampler struct {
+	filters      []int16
+	filterLength int
+	phaseMask    int
+	srcIncrement int
+	dstIncrement int
+	index        int
+	frac         int
+}
+
+func newResampler(outRate, inRate int) *resampler {
+	factor := math.Min(float64(outRate)*resampleCutoff/float64(inRate), 1.0)
+	phases := 1 << resamplePhaseShift
+	r := &resampler{phaseMask: phases - 1}
+	r.filterLength = int(math.Max(math.Ceil(resampleFilterLength/factor), 1))
+	r.filters = buildResampleFilters(factor, r.filterLength, phases)
+
+	g := gcd(outRate, inRate*phases)
+	r.srcIncrement, r.dstIncrement = outRate/g, inRate*phases/g
+	r.index = -phases * ((r.filterLength - 1) / 2)
+	return r
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	v, last, t := 1.0, 0.0, 1.0
+	x = x * x / 4
+	for i := 1.0; v != last; i++ {
+		last = v
+		t *= x / (i * i)
+		v += t
+	}
+	return v
+}
+
+// buildResampleFilters for each phase, Kaiser windowed, in fixed point.
+func buildResampleFilters(factor float64, taps, phases int) []int16 {
+	filters := make([]int16, taps*phases)
+	tab := make([]float64, taps)
+	center := (taps - 1) / 2
+	for ph := 0; ph < phases; ph++ {
+		norm := 0.0
+		for i := 0; i < taps; i++ {
+			x := math.Pi * (float64(i-center) - float64(ph)/float64(phases)) * factor
+			y := 1.0
+			if x != 0 {
+				y = math.Sin(x) / x
+			}
+			w := 2.0 * x / (factor * float64(taps) * math.Pi)
+			y *= besselI0(resampleWindowType * math.Sqrt(math.Max(1-w*w, 0)))
+			tab[i] = y
+			norm += y
+		}
+		for i := 0; i < taps; i++ {
+			v := math.RoundToEven(float64(float32(tab[i] * (1 << resampleFilterShift) / norm)))
+			filters[ph*taps+i] = int16(math.Max(math.Min(v, math.MaxInt16), math.MinInt16))
+		}
+	}
+	return filters
+}
+
+// resample as many samples as possible, returning the output and the number
+// of input samples consumed. Input samples not consumed must be given again.
+func (r *resampler) resample(src []int16) ([]int16, int) {
+	dst := []int16{}
+	dstIncrementFrac := r.dstIncrement % r.srcIncrement
+	dstIncrement := r.dstIncrement / r.srcIncrement
+	for {
+		filter := r.filters[r.filterLength*(r.index&r.phaseMask):]
+		sampleIndex := r.index >> resamplePhaseShift
+		val := int64(0)
+		if sampleIndex < 0 {
+			for i := 0; i < r.filterLength; i++ {
+				k := sampleIndex + i
+				if k < 0 {
+					k = -k
+				}
+				val += int64(src[k%len(src)]) * int64(filter[i])
+			}
+		} else if sampleIndex+r.filterLength > len(src) {
+			break
+		} else {
+			for i := 0; i < r.filterLength; i++ {
+				val += int64(src[sampleIndex+i]) * int64(filter[i])
+			}
+		}
+		val = (val + 1<<(resampleFilterShift-1)) >> resampleFilterShift
+		if val > math.MaxInt16 {
+			val = math.MaxInt16
+		} else if val < math.MinInt16 {
+			val = math.MinInt16
+		}
+		dst = append(dst, int16(val))
+
+		r.frac += dstIncrementFrac
+		r.index += dstIncrement
+		if r.frac >= r.srcIncrement {
+			r.frac -= r.srcIncrement
+			r.index++
+		}
+	}
+	consumed := 0
+	if r.index > 0 {
+		consumed = r.index >> resamplePhaseShift
+	}
+	if r.index >= 0 {
+		r.index &= r.phaseMask
+	}
+	return dst, consumed
+}
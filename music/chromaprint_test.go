@@ -0,0 +1,178 @@
+package music
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMusic is a sequence of random chords, half a second each.
+func testMusic(seconds, sampleRate, channels int, seed uint32) []int16 {
+	samples := make([]int16, 0, seconds*sampleRate*channels)
+	chordLength := sampleRate / 2
+	notes := []float64{}
+	for i := 0; i < seconds*sampleRate; i++ {
+		if i%chordLength == 0 {
+			notes = notes[:0]
+			for j := 0; j < 3; j++ {
+				seed = seed*1664525 + 1013904223
+				notes = append(notes, 110*math.Pow(2, float64(seed>>24%36)/12))
+			}
+		}
+		v := 0.0
+		for _, f := range notes {
+			v += 8000 * math.Sin(2*math.Pi*f*float64(i)/float64(sampleRate))
+		}
+		for c := 0; c < channels; c++ {
+			samples = append(samples, int16(v))
+		}
+	}
+	return samples
+}
+
+func testFingerprint(samples []int16, sampleRate, channels int) []uint32 {
+	c := NewChromaprinter(sampleRate, channels)
+	// feed in uneven chunks, as a decoder would
+	for len(samples) > 0 {
+		n := 4608 * channels
+		if n > len(samples) {
+			n = len(samples)
+		}
+		c.Feed(samples[:n])
+		samples = samples[n:]
+	}
+	return c.Finish()
+}
+
+// bitErrorRate between two raw fingerprints.
+func bitErrorRate(a, b []uint32) float64 {
+	errors, total := 0, 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		errors += bits.OnesCount32(a[i] ^ b[i])
+		total += 32
+	}
+	return float64(errors) / float64(total)
+}
+
+func TestChromaprint(t *testing.T) {
+	fmt.Println("+ Testing Chromaprint...")
+	check := assert.New(t)
+
+	raw := testFingerprint(testMusic(30, 11025, 1, 1), 11025, 1)
+	frames := 1 + (30*11025-chromaprintFrameSize)/chromaprintHop
+	check.Equal(frames-len(chromaFilterCoefficients)+1-chromaprintMaxFilterWidth+1, len(raw))
+	check.Equal(raw, testFingerprint(testMusic(30, 11025, 1, 1), 11025, 1))
+
+	resampled := testFingerprint(testMusic(30, 44100, 2, 1), 44100, 2)
+	check.InDelta(len(raw), len(resampled), 1)
+	check.True(bitErrorRate(raw, resampled) < 0.1, "Same music at another sample rate: %v", bitErrorRate(raw, resampled))
+
+	other := testFingerprint(testMusic(30, 11025, 1, 2), 11025, 1)
+	check.True(bitErrorRate(raw, other) > 0.25, "Different music: %v", bitErrorRate(raw, other))
+
+	silence := testFingerprint(make([]int16, 10*11025), 11025, 1)
+	check.NotEqual(0, len(silence))
+	check.Equal(0, len(testFingerprint(make([]int16, 1000), 11025, 1)))
+}
+
+func TestEncodeFingerprint(t *testing.T) {
+	fmt.Println("+ Testing fingerprint encoding...")
+	check := assert.New(t)
+
+	check.Equal("AQAAAA", EncodeFingerprint([]uint32{}))
+	check.Equal("AQAAAQE", EncodeFingerprint([]uint32{1}))
+	// bit 8 is too far from the start for 3 bits: exception
+	check.Equal("AQAAAgcAAQ", EncodeFingerprint([]uint32{0x80, 0x80}))
+	check.Equal([]byte{0x11, 0x01}, packBits([]uint32{1, 2, 4, 0}, 3))
+
+	raw := testFingerprint(testMusic(10, 11025, 1, 4), 11025, 1)
+	decoded, algorithm, err := DecodeFingerprint(EncodeFingerprint(raw))
+	require.Nil(t, err)
+	check.Equal(chromaprintAlgorithm, algorithm)
+	check.Equal(raw, decoded)
+	decoded, _, err = DecodeFingerprint("AQAAAgcAAQ")
+	require.Nil(t, err)
+	check.Equal([]uint32{0x80, 0x80}, decoded)
+	_, _, err = DecodeFingerprint("AQAAAw")
+	check.NotNil(err)
+	_, _, err = DecodeFingerprint("A")
+	check.NotNil(err)
+}
+
+func TestFingerprintFlac(t *testing.T) {
+	fmt.Println("+ Testing FLAC fingerprinting...")
+	check := assert.New(t)
+
+	music := testMusic(12, 44100, 2, 3)
+	left, right := make([]int32, len(music)/2), make([]int32, len(music)/2)
+	for i := range left {
+		left[i], right[i] = int32(music[2*i]), int32(music[2*i+1])
+	}
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "music.flac")
+	writeTestAudioFlac(t, path, 44100, [][]int32{left, right})
+
+	fingerprint, duration, err := FingerprintFlac(path)
+	require.Nil(t, err)
+	check.Equal(12, duration)
+	check.Equal(EncodeFingerprint(testFingerprint(music, 44100, 2)), fingerprint)
+
+	// only the beginning is fingerprinted
+	defer func(d int) { ChromaprintMaxDuration = d }(ChromaprintMaxDuration)
+	ChromaprintMaxDuration = 10
+	fingerprint, duration, err = FingerprintFlac(path)
+	require.Nil(t, err)
+	check.Equal(12, duration)
+	check.Equal(EncodeFingerprint(testFingerprint(music[:10*44100*2], 44100, 2)), fingerprint)
+
+	// stopped with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = RawFingerprintFlacContext(ctx, path, ChromaprintMaxDuration)
+	check.Equal(context.Canceled, err)
+
+	a := NewAcoustid("key")
+	require.Nil(t, a.CalculateFingerprint(path))
+	check.Equal(fingerprint, a.Fingerprint)
+	check.Equal("12", a.Duration)
+	a.Length = 12
+	require.Nil(t, a.CalculateFingerprint(path))
+	check.Equal(testFingerprint(music, 44100, 2), a.RawFingerprint)
+	check.Equal(EncodeFingerprint(a.RawFingerprint), a.Fingerprint)
+}
+
+func TestFingerprintFlacTracks(t *testing.T) {
+	fmt.Println("+ Testing FLAC fingerprinting against fpcalc...")
+	check := assert.New(t)
+
+	// the test tracks are not all committed, see test/source.md
+	checked := 0
+	for _, track := range testTracks {
+		if filepath.Ext(track.path) != flacExtension {
+			continue
+		}
+		if _, err := os.Stat(track.path); os.IsNotExist(err) {
+			fmt.Println("Missing test track " + track.path)
+			continue
+		}
+		fingerprint, duration, err := FingerprintFlac(track.path)
+		require.Nil(t, err)
+		check.Equal(track.duration, strconv.Itoa(duration))
+		check.Equal(track.fingerprint, fingerprint, "Fingerprint of %s differs from fpcalc", track.path)
+		checked++
+	}
+	if checked == 0 {
+		t.Skip("No FLAC test track in ../test, see test/source.md")
+	}
+}
@@ -0,0 +1,204 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	coverArtArchiveURL = "https://coverartarchive.org"
+)
+
+// CoverArtArchiveOptions for new Cover Art Archive lookups.
+var CoverArtArchiveOptions = ProviderOptions{}
+
+// Cover Art Archive image types that map to FLAC picture types.
+const (
+	CoverArtFront   = "Front"
+	CoverArtBack    = "Back"
+	CoverArtBooklet = "Booklet"
+	CoverArtMedium  = "Medium"
+)
+
+// CoverArtImage is an image listed by the Cover Art Archive for a release.
+type CoverArtImage struct {
+	Approved   bool              `json:"approved"`
+	Back       bool              `json:"back"`
+	Comment    string            `json:"comment"`
+	Edit       int               `json:"edit"`
+	Front      bool              `json:"front"`
+	ID         json.Number       `json:"id"`
+	Image      string            `json:"image"`
+	Thumbnails map[string]string `json:"thumbnails"`
+	Types      []string          `json:"types"`
+}
+
+// HasType checks if the image is tagged with a Cover Art Archive type.
+func (i CoverArtImage) HasType(imageType string) bool {
+	for _, t := range i.Types {
+		if strings.EqualFold(t, imageType) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlacPictureType matching the image types.
+func (i CoverArtImage) FlacPictureType() uint32 {
+	switch {
+	case i.Front || i.HasType(CoverArtFront):
+		return PictureFrontCover
+	case i.Back || i.HasType(CoverArtBack):
+		return PictureBackCover
+	case i.HasType(CoverArtBooklet):
+		return PictureLeaflet
+	case i.HasType(CoverArtMedium):
+		return PictureMedia
+	}
+	return PictureOther
+}
+
+// CoverArtArchiveResults is a struct describing the JSON index of a release on the Cover Art Archive.
+type CoverArtArchiveResults struct {
+	Images  []CoverArtImage `json:"images"`
+	Release string          `json:"release"`
+}
+
+// CoverArtArchive retrieves artwork for a MusicBrainz release.
+type CoverArtArchive struct {
+	Release *MusicBrainzRelease
+	Options ProviderOptions
+	Info    CoverArtArchiveResults
+}
+
+// NewCoverArtArchive set up for a MusicBrainz release.
+func NewCoverArtArchive(release *MusicBrainzRelease) *CoverArtArchive {
+	return &CoverArtArchive{Release: release, Options: CoverArtArchiveOptions}
+}
+
+// HasArtwork according to MusicBrainz.
+// If the release information has not been retrieved yet, assume it does.
+func (c *CoverArtArchive) HasArtwork() bool {
+	if c.Release.Info.ID == "" {
+		return true
+	}
+	caa := c.Release.Info.CoverArtArchive
+	return caa.Artwork || caa.Front || caa.Back || caa.Count != 0
+}
+
+// GetInfo lists the images available for the release.
+func (c *CoverArtArchive) GetInfo() error {
+	return c.GetInfoContext(context.Background())
+}
+
+// GetInfoContext lists the images available for the release, until the context is done.
+func (c *CoverArtArchive) GetInfoContext(ctx context.Context) error {
+	if c.Release.ID == "" {
+		return errors.New("MusicBrainz release ID is required")
+	}
+	if !c.HasArtwork() {
+		return errors.New("No cover art for this release")
+	}
+	data, err := c.get(ctx, c.Options.url(coverArtArchiveURL, "/release/"+c.Release.ID))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Info)
+}
+
+// Images of a given Cover Art Archive type, or all images if the type is empty.
+func (c *CoverArtArchive) Images(imageType string) []CoverArtImage {
+	images := []CoverArtImage{}
+	for _, i := range c.Info.Images {
+		if imageType == "" || i.HasType(imageType) {
+			images = append(images, i)
+		}
+	}
+	return images
+}
+
+// Front cover of the release, or nil.
+func (c *CoverArtArchive) Front() *CoverArtImage {
+	for i := range c.Info.Images {
+		if c.Info.Images[i].Front {
+			return &c.Info.Images[i]
+		}
+	}
+	return nil
+}
+
+// Back cover of the release, or nil.
+func (c *CoverArtArchive) Back() *CoverArtImage {
+	for i := range c.Info.Images {
+		if c.Info.Images[i].Back {
+			return &c.Info.Images[i]
+		}
+	}
+	return nil
+}
+
+// Download an image. Size can be a thumbnail size ("250", "500", "1200",
+// "small", "large"), or empty for the original image.
+func (c *CoverArtArchive) Download(image *CoverArtImage, size string) ([]byte, error) {
+	return c.DownloadContext(context.Background(), image, size)
+}
+
+// DownloadContext an image, until the context is done.
+func (c *CoverArtArchive) DownloadContext(ctx context.Context, image *CoverArtImage, size string) ([]byte, error) {
+	url := image.Image
+	if size != "" {
+		thumbnail, ok := image.Thumbnails[size]
+		if !ok {
+			return nil, errors.New("No thumbnail of size " + size)
+		}
+		url = thumbnail
+	}
+	return c.get(ctx, url)
+}
+
+// DownloadFront cover, skipping the lookup entirely if MusicBrainz knows there is none.
+func (c *CoverArtArchive) DownloadFront(size string) (*FlacPicture, error) {
+	return c.DownloadFrontContext(context.Background(), size)
+}
+
+// DownloadFrontContext cover, until the context is done.
+func (c *CoverArtArchive) DownloadFrontContext(ctx context.Context, size string) (*FlacPicture, error) {
+	if c.Release.Info.ID != "" && !c.Release.Info.CoverArtArchive.Front {
+		return nil, errors.New("No front cover for this release")
+	}
+	if len(c.Info.Images) == 0 {
+		if err := c.GetInfoContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	image := c.Front()
+	if image == nil {
+		return nil, errors.New("No front cover for this release")
+	}
+	data, err := c.DownloadContext(ctx, image, size)
+	if err != nil {
+		return nil, err
+	}
+	return NewFlacPicture(image.FlacPictureType(), data, image.Comment)
+}
+
+func (c *CoverArtArchive) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.Options.userAgent(UserAgent))
+	resp, err := c.Options.httpClient(nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Returned status: " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,134 @@
+package music
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCoverArtIndex = `{
+  "images": [
+    {
+      "approved": true, "back": false, "comment": "", "edit": 17727549, "front": true,
+      "id": 4553472911,
+      "image": "%[1]s/release/%[2]s/4553472911.jpg",
+      "thumbnails": {
+        "250": "%[1]s/release/%[2]s/4553472911-250.jpg",
+        "500": "%[1]s/release/%[2]s/4553472911-500.jpg",
+        "small": "%[1]s/release/%[2]s/4553472911-250.jpg",
+        "large": "%[1]s/release/%[2]s/4553472911-500.jpg"
+      },
+      "types": ["Front"]
+    },
+    {
+      "approved": true, "back": true, "comment": "with barcode", "edit": 17727550, "front": false,
+      "id": 4553473223,
+      "image": "%[1]s/release/%[2]s/4553473223.jpg",
+      "thumbnails": {},
+      "types": ["Back", "Spine"]
+    },
+    {
+      "approved": true, "back": false, "comment": "", "edit": 17727551, "front": false,
+      "id": 4553473500,
+      "image": "%[1]s/release/%[2]s/4553473500.jpg",
+      "thumbnails": {},
+      "types": ["Booklet"]
+    }
+  ],
+  "release": "https://musicbrainz.org/release/%[2]s"
+}`
+
+func newTestCoverArtServer(t *testing.T, mbid string, requests *int) *httptest.Server {
+	front := testImage(t, "jpeg", 50, 50)
+	thumbnail := testImage(t, "jpeg", 25, 25)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		switch {
+		case r.URL.Path == "/release/"+mbid:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, testCoverArtIndex, server.URL, mbid)
+		case strings.HasSuffix(r.URL.Path, "-250.jpg"):
+			w.Write(thumbnail)
+		case strings.HasSuffix(r.URL.Path, ".jpg"):
+			w.Write(front)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestCoverArtArchive(t *testing.T) {
+	fmt.Println("+ Testing Cover Art Archive...")
+	check := assert.New(t)
+
+	mbid := testMBReleases[1].mbReleaseID
+	requests := 0
+	server := newTestCoverArtServer(t, mbid, &requests)
+	defer server.Close()
+
+	release := NewMusicBrainzRelease(mbid)
+	c := NewCoverArtArchive(release)
+	c.Options.BaseURL = server.URL
+	check.True(c.HasArtwork(), "Unknown artwork status should trigger a lookup")
+
+	require.Nil(t, c.GetInfo())
+	check.Equal(3, len(c.Info.Images))
+	check.Equal(3, len(c.Images("")))
+	check.Equal(1, len(c.Images(CoverArtBooklet)))
+	check.Equal(0, len(c.Images(CoverArtMedium)))
+
+	front := c.Front()
+	require.NotNil(t, front)
+	check.Equal("4553472911", front.ID.String())
+	check.Equal(PictureFrontCover, front.FlacPictureType())
+	back := c.Back()
+	require.NotNil(t, back)
+	check.Equal("with barcode", back.Comment)
+	check.Equal(PictureBackCover, back.FlacPictureType())
+	check.Equal(PictureLeaflet, c.Images(CoverArtBooklet)[0].FlacPictureType())
+
+	// downloads
+	_, err := c.Download(back, "500")
+	check.NotNil(err, "Back cover has no thumbnails")
+	picture, err := c.DownloadFront("250")
+	require.Nil(t, err)
+	check.Equal(uint32(25), picture.Width)
+	check.Equal(PictureFrontCover, picture.Type)
+	picture, err = c.DownloadFront("")
+	require.Nil(t, err)
+	check.Equal(uint32(50), picture.Width)
+	check.Equal("image/jpeg", picture.MIME)
+
+	// unknown release
+	c = NewCoverArtArchive(NewMusicBrainzRelease("00000000-0000-0000-0000-000000000000"))
+	c.Options.BaseURL = server.URL
+	check.NotNil(c.GetInfo())
+
+	// MusicBrainz says there is no artwork: no request at all
+	requests = 0
+	release.Info.ID = mbid
+	c = NewCoverArtArchive(release)
+	c.Options.BaseURL = server.URL
+	check.False(c.HasArtwork())
+	check.NotNil(c.GetInfo())
+	_, err = c.DownloadFront("")
+	check.NotNil(err)
+	check.Equal(0, requests)
+
+	// only a back cover
+	release.Info.CoverArtArchive.Artwork = true
+	release.Info.CoverArtArchive.Back = true
+	check.True(c.HasArtwork())
+	_, err = c.DownloadFront("")
+	check.NotNil(err)
+	check.Equal(0, requests)
+	require.Nil(t, c.GetInfo())
+	check.Equal(1, requests)
+}
@@ -1,6 +1,7 @@
 package music
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,10 +15,14 @@ import (
 )
 
 const (
-	discogsSearchURL = "https://api.discogs.com/database/search"
+	discogsURL       = "https://api.discogs.com"
+	discogsSearchURL = "/database/search"
 	credentialsFile  = "discogs_credentials.json"
 )
 
+// DiscogsOptions for new Discogs releases.
+var DiscogsOptions = ProviderOptions{}
+
 type DiscogsResults struct {
 	Pagination struct {
 		Items   int      `json:"items"`
@@ -33,18 +38,20 @@ type DiscogsResults struct {
 			Have int `json:"have"`
 			Want int `json:"want"`
 		} `json:"community"`
-		Country     string   `json:"country"`
-		Format      []string `json:"format"`
-		Genre       []string `json:"genre"`
-		ID          int      `json:"id"`
-		Label       []string `json:"label"`
-		ResourceURL string   `json:"resource_url"`
-		Style       []string `json:"style"`
-		Thumb       string   `json:"thumb"`
-		Title       string   `json:"title"`
-		Type        string   `json:"type"`
-		URI         string   `json:"uri"`
-		Year        string   `json:"year"`
+		Country        string   `json:"country"`
+		Format         []string `json:"format"`
+		FormatQuantity int      `json:"format_quantity"`
+		Genre          []string `json:"genre"`
+		ID             int      `json:"id"`
+		Label          []string `json:"label"`
+		MasterID       int      `json:"master_id"`
+		ResourceURL    string   `json:"resource_url"`
+		Style          []string `json:"style"`
+		Thumb          string   `json:"thumb"`
+		Title          string   `json:"title"`
+		Type           string   `json:"type"`
+		URI            string   `json:"uri"`
+		Year           string   `json:"year"`
 	} `json:"results"`
 }
 
@@ -56,12 +63,16 @@ type DiscogsRelease struct {
 	UserToken       string
 	UserSecret      string
 	Client          oauth.Client
+	Options         ProviderOptions
 	Info            DiscogsResults
+	Details         DiscogsReleaseResults
+	Master          DiscogsMasterResults
+	Versions        []DiscogsVersion
 }
 
 // NewDiscogsRelease set up with Discogs API authorization info.
 func NewDiscogsRelease(token, secret string) *DiscogsRelease {
-	return &DiscogsRelease{Token: token, Secret: secret, CredentialsFile: credentialsFile}
+	return &DiscogsRelease{Token: token, Secret: secret, CredentialsFile: credentialsFile, Options: DiscogsOptions}
 }
 
 func (d *DiscogsRelease) readCredentials() error {
@@ -91,10 +102,10 @@ func (d *DiscogsRelease) saveCredentials() error {
 func (d *DiscogsRelease) Authorize(ui u.UserInterface) error {
 	// init client
 	d.Client = oauth.Client{
-		TemporaryCredentialRequestURI: "https://api.discogs.com/oauth/request_token",
+		TemporaryCredentialRequestURI: d.Options.url(discogsURL, "/oauth/request_token"),
 		ResourceOwnerAuthorizationURI: "https://www.discogs.com/oauth/authorize",
-		TokenRequestURI:               "https://api.discogs.com/oauth/access_token",
-		Header:                        http.Header{"User-Agent": {"AUBERGINE/1.0"}},
+		TokenRequestURI:               d.Options.url(discogsURL, "/oauth/access_token"),
+		Header:                        http.Header{"User-Agent": {d.Options.userAgent(UserAgent)}},
 	}
 	d.Client.Credentials.Token = d.Token
 	d.Client.Credentials.Secret = d.Secret
@@ -103,7 +114,7 @@ func (d *DiscogsRelease) Authorize(ui u.UserInterface) error {
 	if err := d.readCredentials(); err != nil {
 		ui.Warning("Could not get credentials, authorizing with Discogs.")
 		// if we cant't, get them from discogs
-		tempCred, err := d.Client.RequestTemporaryCredentials(nil, "", nil)
+		tempCred, err := d.Client.RequestTemporaryCredentials(d.Options.httpClient(nil), "", nil)
 		if err != nil {
 			fmt.Println(err.Error())
 			return err
@@ -125,7 +136,7 @@ func (d *DiscogsRelease) Authorize(ui u.UserInterface) error {
 
 		//tempTokenCred := &oauth.Credentials{Token:tempToken}
 
-		tokenCred, _, err := d.Client.RequestToken(nil, tempCred, tempToken)
+		tokenCred, _, err := d.Client.RequestToken(d.Options.httpClient(nil), tempCred, tempToken)
 		if err != nil {
 			ui.Error("Could not request token!")
 			return err
@@ -141,6 +152,11 @@ func (d *DiscogsRelease) Authorize(ui u.UserInterface) error {
 
 // LookUp release on Discogs and retrieve its information
 func (d *DiscogsRelease) LookUp(artist, release string) error {
+	return d.LookUpContext(context.Background(), artist, release)
+}
+
+// LookUpContext release on Discogs and retrieve its information, until the context is done.
+func (d *DiscogsRelease) LookUpContext(ctx context.Context, artist, release string) error {
 	// TODO check authorized
 	// TODO see what to return
 
@@ -154,26 +170,40 @@ func (d *DiscogsRelease) LookUp(artist, release string) error {
 	q.Set("artist", artist)
 	q.Set("release_title", release)
 	searchURL.RawQuery = q.Encode()
+	return d.search(ctx, q)
+}
 
-	respp, err := d.Client.Get(nil, &oauth.Credentials{Token: d.UserToken, Secret: d.UserSecret}, discogsSearchURL, q)
+// search Discogs releases with any supported search parameter.
+func (d *DiscogsRelease) search(ctx context.Context, q url.Values) error {
+	resultDCBytes, err := d.get(ctx, discogsSearchURL, q)
 	if err != nil {
 		return err
 	}
-
-	defer respp.Body.Close()
-	if respp.StatusCode != http.StatusOK {
-		return errors.New("Returned status: " + respp.Status)
+	if err := json.Unmarshal(resultDCBytes, &d.Info); err != nil {
+		return errors.New("Could not read JSON data from Discogs.")
 	}
+	return nil
+}
 
-	resultDCBytes, err := ioutil.ReadAll(respp.Body)
+// get a Discogs API endpoint, authenticated.
+func (d *DiscogsRelease) get(ctx context.Context, endpoint string, q url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.Options.url(discogsURL, endpoint), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if err := json.Unmarshal(resultDCBytes, &d.Info); err != nil {
-		return errors.New("Could not read JSON data from Discogs.")
+	// the query is signed separately, then added to the request
+	if err := d.Client.SetAuthorizationHeader(req.Header, &oauth.Credentials{Token: d.UserToken, Secret: d.UserSecret}, "GET", req.URL, q); err != nil {
+		return nil, err
 	}
-
-	// TODO retrieve track list!! GET https://api.discogs.com/releases/{release_id}
-	return nil
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("User-Agent", d.Options.userAgent(UserAgent))
+	respp, err := d.Options.httpClient(nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer respp.Body.Close()
+	if respp.StatusCode != http.StatusOK {
+		return nil, errors.New("Returned status: " + respp.Status)
+	}
+	return ioutil.ReadAll(respp.Body)
 }
@@ -0,0 +1,167 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	discogsMasterURL         = "/masters/%d"
+	discogsMasterVersionsURL = "/masters/%d/versions"
+	discogsVersionsPerPage   = 100
+)
+
+// DiscogsMasterResults is a struct describing the JSON response from Discogs for a master release.
+type DiscogsMasterResults struct {
+	Artists     DiscogsArtists `json:"artists"`
+	DataQuality string         `json:"data_quality"`
+	Genres      []string       `json:"genres"`
+	ID          int            `json:"id"`
+	Images      []struct {
+		Height int    `json:"height"`
+		Type   string `json:"type"`
+		URI    string `json:"uri"`
+		URI150 string `json:"uri150"`
+		Width  int    `json:"width"`
+	} `json:"images"`
+	MainRelease       int            `json:"main_release"`
+	MostRecentRelease int            `json:"most_recent_release"`
+	ResourceURL       string         `json:"resource_url"`
+	Styles            []string       `json:"styles"`
+	Title             string         `json:"title"`
+	Tracklist         []DiscogsTrack `json:"tracklist"`
+	URI               string         `json:"uri"`
+	VersionsURL       string         `json:"versions_url"`
+	Year              int            `json:"year"`
+}
+
+// DiscogsVersion is a release (pressing) of a master release.
+type DiscogsVersion struct {
+	Catno        string   `json:"catno"`
+	Country      string   `json:"country"`
+	Format       string   `json:"format"`
+	ID           int      `json:"id"`
+	Label        string   `json:"label"`
+	MajorFormats []string `json:"major_formats"`
+	Released     string   `json:"released"`
+	ResourceURL  string   `json:"resource_url"`
+	Status       string   `json:"status"`
+	Thumb        string   `json:"thumb"`
+	Title        string   `json:"title"`
+}
+
+// Year the version was released, or 0 if unknown.
+func (v DiscogsVersion) Year() int {
+	if len(v.Released) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(v.Released[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// String representation of the version, to help choose a pressing.
+func (v DiscogsVersion) String() string {
+	return fmt.Sprintf("%d: %s [%s] %s %s (%s)", v.ID, v.Label, v.Catno, v.Country, v.Released, v.Format)
+}
+
+// DiscogsVersionsResults is a struct describing the JSON response from Discogs for the versions of a master release.
+type DiscogsVersionsResults struct {
+	Pagination struct {
+		Items   int `json:"items"`
+		Page    int `json:"page"`
+		Pages   int `json:"pages"`
+		PerPage int `json:"per_page"`
+	} `json:"pagination"`
+	Versions []DiscogsVersion `json:"versions"`
+}
+
+// MasterID of a search hit: the master of a release, or the hit itself if it is a master.
+func (r *DiscogsResults) MasterID(hit int) int {
+	if hit < 0 || hit >= len(r.Results) {
+		return 0
+	}
+	if r.Results[hit].Type == "master" {
+		return r.Results[hit].ID
+	}
+	return r.Results[hit].MasterID
+}
+
+// GetMaster retrieves the canonical master release.
+func (d *DiscogsRelease) GetMaster(id int) error {
+	return d.GetMasterContext(context.Background(), id)
+}
+
+// GetMasterContext retrieves the canonical master release, until the context is done.
+func (d *DiscogsRelease) GetMasterContext(ctx context.Context, id int) error {
+	if id == 0 {
+		return errors.New("Invalid Discogs master ID")
+	}
+	data, err := d.get(ctx, fmt.Sprintf(discogsMasterURL, id), nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.Master); err != nil {
+		return errors.New("Could not read JSON data from Discogs.")
+	}
+	return nil
+}
+
+// GetVersions retrieves every version of a master release, going through all result pages.
+func (d *DiscogsRelease) GetVersions(masterID int) error {
+	return d.GetVersionsContext(context.Background(), masterID)
+}
+
+// GetVersionsContext retrieves every version of a master release, until the context is done.
+func (d *DiscogsRelease) GetVersionsContext(ctx context.Context, masterID int) error {
+	if masterID == 0 {
+		return errors.New("Invalid Discogs master ID")
+	}
+	d.Versions = []DiscogsVersion{}
+	for page, pages := 1, 1; page <= pages; page++ {
+		q := url.Values{}
+		q.Set("page", strconv.Itoa(page))
+		q.Set("per_page", strconv.Itoa(discogsVersionsPerPage))
+		data, err := d.get(ctx, fmt.Sprintf(discogsMasterVersionsURL, masterID), q)
+		if err != nil {
+			return err
+		}
+		results := DiscogsVersionsResults{}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return errors.New("Could not read JSON data from Discogs.")
+		}
+		d.Versions = append(d.Versions, results.Versions...)
+		pages = results.Pagination.Pages
+	}
+	return nil
+}
+
+// FilterVersions by country, format, label, catalog number and year.
+// Empty (or zero) criteria are ignored; text comparisons are case-insensitive
+// and ignore spaces and dashes in catalog numbers.
+func (d *DiscogsRelease) FilterVersions(country, format, label, catno string, year int) []DiscogsVersion {
+	rp := strings.NewReplacer(" ", "", "-", "")
+	contains := func(value, filter string) bool {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(filter))
+	}
+	versions := []DiscogsVersion{}
+	for _, v := range d.Versions {
+		switch {
+		case country != "" && !strings.EqualFold(v.Country, country):
+		case format != "" && !contains(v.Format+" "+strings.Join(v.MajorFormats, " "), format):
+		case label != "" && !contains(v.Label, label):
+		case catno != "" && !strings.EqualFold(rp.Replace(v.Catno), rp.Replace(catno)):
+		case year != 0 && v.Year() != year:
+		default:
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
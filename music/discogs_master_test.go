@@ -0,0 +1,72 @@
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDiscogsMasterJSON = `{
+  "id": 21491, "title": "Kid A", "year": 2000, "main_release": 1388513, "most_recent_release": 9999999,
+  "versions_url": "https://api.discogs.com/masters/21491/versions",
+  "artists": [{"anv": "", "id": 3840, "join": "", "name": "Radiohead", "role": ""}],
+  "genres": ["Electronic", "Rock"], "styles": ["Alternative Rock", "IDM"],
+  "tracklist": [{"position": "1", "type_": "track", "title": "Everything In Its Right Place", "duration": "4:11"}]
+}`
+
+const testDiscogsVersionsJSON = `{
+  "pagination": {"items": 4, "page": 1, "pages": 1, "per_page": 100},
+  "versions": [
+    {"id": 1388513, "title": "Kid A", "label": "Parlophone", "catno": "527 7532", "country": "UK",
+     "format": "CD, Album", "major_formats": ["CD"], "released": "2000-10-02", "status": "Accepted"},
+    {"id": 82796, "title": "Kid A", "label": "Capitol Records", "catno": "CDP 7243 5 27753 2 3", "country": "US",
+     "format": "CD, Album", "major_formats": ["CD"], "released": "2000-10-03", "status": "Accepted"},
+    {"id": 1158, "title": "Kid A", "label": "Parlophone", "catno": "7243 5 27753 1 0", "country": "UK",
+     "format": "2x10\", Album, Ltd", "major_formats": ["Vinyl"], "released": "2000", "status": "Accepted"},
+    {"id": 555, "title": "Kid A", "label": "XL Recordings", "catno": "XLLP 782", "country": "Europe",
+     "format": "2x10\", Album, RE", "major_formats": ["Vinyl"], "released": "", "status": "Accepted"}
+  ]
+}`
+
+func TestDiscogsMaster(t *testing.T) {
+	fmt.Println("+ Testing Discogs master releases...")
+	check := assert.New(t)
+
+	d := NewDiscogsRelease("", "")
+	check.NotNil(d.GetMaster(0))
+	check.NotNil(d.GetVersions(0))
+
+	require.Nil(t, json.Unmarshal([]byte(testDiscogsMasterJSON), &d.Master))
+	check.Equal(1388513, d.Master.MainRelease)
+	check.Equal("Radiohead", d.Master.Artists.String())
+	check.Equal(2000, d.Master.Year)
+
+	versions := DiscogsVersionsResults{}
+	require.Nil(t, json.Unmarshal([]byte(testDiscogsVersionsJSON), &versions))
+	d.Versions = versions.Versions
+	require.Equal(t, 4, len(d.Versions))
+	check.Equal(2000, d.Versions[0].Year())
+	check.Equal(0, d.Versions[3].Year())
+	check.Equal("1388513: Parlophone [527 7532] UK 2000-10-02 (CD, Album)", d.Versions[0].String())
+
+	check.Equal(4, len(d.FilterVersions("", "", "", "", 0)))
+	check.Equal(2, len(d.FilterVersions("uk", "", "", "", 0)))
+	check.Equal(2, len(d.FilterVersions("", "vinyl", "", "", 0)))
+	check.Equal(1, len(d.FilterVersions("UK", "CD", "parlophone", "", 2000)))
+	found := d.FilterVersions("", "", "", "5277532", 0)
+	require.Equal(t, 1, len(found))
+	check.Equal(1388513, found[0].ID)
+	check.Equal(0, len(d.FilterVersions("", "", "", "", 1999)))
+
+	// master IDs from search hits
+	hits := DiscogsResults{}
+	require.Nil(t, json.Unmarshal([]byte(`{"results": [
+		{"id": 1388513, "type": "release", "master_id": 21491},
+		{"id": 21491, "type": "master"}]}`), &hits))
+	check.Equal(21491, hits.MasterID(0))
+	check.Equal(21491, hits.MasterID(1))
+	check.Equal(0, hits.MasterID(2))
+}
@@ -0,0 +1,275 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	discogsReleaseURL = "/releases/%d"
+)
+
+// Discogs tracklist entry types.
+const (
+	DiscogsTrackType   = "track"
+	DiscogsHeadingType = "heading"
+	DiscogsIndexType   = "index"
+)
+
+// DiscogsArtist is an artist credited on a release or a track.
+type DiscogsArtist struct {
+	Anv         string `json:"anv"`
+	ID          int    `json:"id"`
+	Join        string `json:"join"`
+	Name        string `json:"name"`
+	ResourceURL string `json:"resource_url"`
+	Role        string `json:"role"`
+	Tracks      string `json:"tracks"`
+}
+
+// CreditedName of the artist: the name variation used on the release, if any.
+func (a DiscogsArtist) CreditedName() string {
+	if a.Anv != "" {
+		return a.Anv
+	}
+	return a.Name
+}
+
+// DiscogsArtists is a list of artists credited together.
+type DiscogsArtists []DiscogsArtist
+
+// String representation of the credit, as it should be displayed.
+func (artists DiscogsArtists) String() string {
+	credit := ""
+	for i, a := range artists {
+		credit += a.CreditedName()
+		if i != len(artists)-1 {
+			switch a.Join {
+			case "", ",":
+				credit += a.Join + " "
+			default:
+				credit += " " + a.Join + " "
+			}
+		}
+	}
+	return credit
+}
+
+// DiscogsTrack is a tracklist entry: a track, a heading, or an index track grouping sub tracks.
+type DiscogsTrack struct {
+	Artists      DiscogsArtists `json:"artists"`
+	Duration     string         `json:"duration"`
+	ExtraArtists DiscogsArtists `json:"extraartists"`
+	Position     string         `json:"position"`
+	SubTracks    []DiscogsTrack `json:"sub_tracks"`
+	Title        string         `json:"title"`
+	Type         string         `json:"type_"`
+}
+
+// Length of the track, parsed from its duration ("4:31", "1:02:03").
+func (t DiscogsTrack) Length() time.Duration {
+	return parseDiscogsDuration(t.Duration)
+}
+
+func parseDiscogsDuration(duration string) time.Duration {
+	length := time.Duration(0)
+	if duration == "" {
+		return length
+	}
+	for _, part := range strings.Split(duration, ":") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0
+		}
+		length = length*60 + time.Duration(value)
+	}
+	return length * time.Second
+}
+
+// DiscogsReleaseResults is a struct describing the JSON response from Discogs for a release.
+type DiscogsReleaseResults struct {
+	Artists   DiscogsArtists `json:"artists"`
+	Companies []struct {
+		Catno          string `json:"catno"`
+		EntityType     string `json:"entity_type"`
+		EntityTypeName string `json:"entity_type_name"`
+		ID             int    `json:"id"`
+		Name           string `json:"name"`
+	} `json:"companies"`
+	Country      string         `json:"country"`
+	DataQuality  string         `json:"data_quality"`
+	ExtraArtists DiscogsArtists `json:"extraartists"`
+	Formats      []struct {
+		Descriptions []string `json:"descriptions"`
+		Name         string   `json:"name"`
+		Qty          string   `json:"qty"`
+		Text         string   `json:"text"`
+	} `json:"formats"`
+	Genres      []string `json:"genres"`
+	ID          int      `json:"id"`
+	Identifiers []struct {
+		Description string `json:"description"`
+		Type        string `json:"type"`
+		Value       string `json:"value"`
+	} `json:"identifiers"`
+	Images []struct {
+		Height      int    `json:"height"`
+		ResourceURL string `json:"resource_url"`
+		Type        string `json:"type"`
+		URI         string `json:"uri"`
+		URI150      string `json:"uri150"`
+		Width       int    `json:"width"`
+	} `json:"images"`
+	Labels []struct {
+		Catno      string `json:"catno"`
+		EntityType string `json:"entity_type"`
+		ID         int    `json:"id"`
+		Name       string `json:"name"`
+	} `json:"labels"`
+	MasterID  int            `json:"master_id"`
+	MasterURL string         `json:"master_url"`
+	Notes     string         `json:"notes"`
+	Released  string         `json:"released"`
+	Status    string         `json:"status"`
+	Styles    []string       `json:"styles"`
+	Title     string         `json:"title"`
+	Tracklist []DiscogsTrack `json:"tracklist"`
+	URI       string         `json:"uri"`
+	Videos    []struct {
+		Description string `json:"description"`
+		Duration    int    `json:"duration"`
+		Embed       bool   `json:"embed"`
+		Title       string `json:"title"`
+		URI         string `json:"uri"`
+	} `json:"videos"`
+	Year int `json:"year"`
+}
+
+// IdentifierValues of a given type ("Barcode", "Matrix / Runout", ...).
+func (r *DiscogsReleaseResults) IdentifierValues(identifierType string) []string {
+	values := []string{}
+	for _, i := range r.Identifiers {
+		if strings.EqualFold(i.Type, identifierType) {
+			values = append(values, i.Value)
+		}
+	}
+	return values
+}
+
+// Barcode of the release, or an empty string.
+func (r *DiscogsReleaseResults) Barcode() string {
+	if barcodes := r.IdentifierValues("Barcode"); len(barcodes) != 0 {
+		return barcodes[0]
+	}
+	return ""
+}
+
+var (
+	// "3a", "A2.b": parts of a track
+	discogsPartPosition = regexp.MustCompile(`^(.*?\d+)\.?([a-z])$`)
+	// "3.1": parts of a track, only in index tracks
+	discogsIndexPartPosition = regexp.MustCompile(`^(.*?\d+)\.(\d+)$`)
+	// "1-3", "CD2-4", and "2.5" outside index tracks
+	discogsDiscPosition = regexp.MustCompile(`^[A-Za-z]*(\d+)[-.](\d+)$`)
+	// "A", "B2", vinyl sides
+	discogsSidePosition = regexp.MustCompile(`^([A-Z])(\d*)$`)
+)
+
+// discogsPosition is a track position on Discogs, split into its parts.
+type discogsPosition struct {
+	Track string // position of the whole track ("3" for "3a")
+	Part  string // part of the track, if any ("a" for "3a")
+	Disc  int    // disc number, if given ("1-3", "CD1-3", "1.3")
+	Side  string // vinyl side, if any ("B" for "B2")
+}
+
+// parseDiscogsPosition of a track, or of a sub track of an index track.
+// Discogs only numbers parts of a track "N.M" in index tracks,
+// elsewhere it stands for track M of disc N.
+func parseDiscogsPosition(position string, subTrack bool) discogsPosition {
+	p := discogsPosition{Track: position}
+	if m := discogsPartPosition.FindStringSubmatch(position); m != nil {
+		p.Track, p.Part = m[1], m[2]
+	} else if m := discogsIndexPartPosition.FindStringSubmatch(position); m != nil && subTrack {
+		p.Track, p.Part = m[1], m[2]
+	}
+	if m := discogsDiscPosition.FindStringSubmatch(p.Track); m != nil {
+		p.Disc, _ = strconv.Atoi(m[1])
+	} else if m := discogsSidePosition.FindStringSubmatch(p.Track); m != nil {
+		p.Side = m[1]
+	}
+	return p
+}
+
+// Tracks of the release, as they would be tagged: headings are skipped,
+// index tracks whose sub tracks are parts of a single track are merged,
+// and index tracks grouping complete tracks are expanded.
+// All other entries are kept, even without position or duration.
+func (r *DiscogsReleaseResults) Tracks() []DiscogsTrack {
+	tracks := []DiscogsTrack{}
+	for _, t := range r.Tracklist {
+		switch t.Type {
+		case DiscogsHeadingType:
+			continue
+		case DiscogsIndexType:
+			tracks = append(tracks, mergeIndexTrack(t)...)
+		default:
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+func mergeIndexTrack(index DiscogsTrack) []DiscogsTrack {
+	if len(index.SubTracks) == 0 {
+		return []DiscogsTrack{}
+	}
+	base := parseDiscogsPosition(index.SubTracks[0].Position, true).Track
+	for _, s := range index.SubTracks {
+		p := parseDiscogsPosition(s.Position, true)
+		if s.Position == "" || p.Track != base || p.Part == "" {
+			// sub tracks are complete tracks
+			return index.SubTracks
+		}
+	}
+	merged := index
+	merged.Type = DiscogsTrackType
+	merged.Position = base
+	merged.SubTracks = nil
+	if merged.Duration == "" {
+		length := time.Duration(0)
+		for _, s := range index.SubTracks {
+			length += s.Length()
+		}
+		if length != 0 {
+			merged.Duration = fmt.Sprintf("%d:%02d", int(length.Minutes()), int(length.Seconds())%60)
+		}
+	}
+	return []DiscogsTrack{merged}
+}
+
+// GetRelease retrieves the full information about a release, including its tracklist.
+func (d *DiscogsRelease) GetRelease(id int) error {
+	return d.GetReleaseContext(context.Background(), id)
+}
+
+// GetReleaseContext retrieves the full information about a release, until the context is done.
+func (d *DiscogsRelease) GetReleaseContext(ctx context.Context, id int) error {
+	if id == 0 {
+		return errors.New("Invalid Discogs release ID")
+	}
+	data, err := d.get(ctx, fmt.Sprintf(discogsReleaseURL, id), nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.Details); err != nil {
+		return errors.New("Could not read JSON data from Discogs.")
+	}
+	return nil
+}
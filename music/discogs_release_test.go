@@ -0,0 +1,108 @@
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trimmed response for a vinyl release, with headings and index tracks
+const testDiscogsReleaseJSON = `{
+  "id": 249504,
+  "title": "Never Gonna Give You Up",
+  "year": 1987,
+  "country": "UK",
+  "released": "1987",
+  "master_id": 96559,
+  "notes": "UK Release has a black label with the text \"Manufactured In England\" printed on it.",
+  "artists": [
+    {"anv": "", "id": 72872, "join": "&", "name": "Rick Astley (2)", "role": ""},
+    {"anv": "The Band", "id": 12, "join": "", "name": "Some Band", "role": ""}
+  ],
+  "extraartists": [
+    {"anv": "", "id": 20942, "join": "", "name": "Stock, Aitken & Waterman", "role": "Producer", "tracks": "A, B"}
+  ],
+  "labels": [{"catno": "PB 41447", "entity_type": "1", "id": 895, "name": "RCA"}],
+  "formats": [{"descriptions": ["7\"", "Single", "45 RPM"], "name": "Vinyl", "qty": "1"}],
+  "identifiers": [
+    {"type": "Barcode", "value": "5012394144777"},
+    {"type": "Matrix / Runout", "description": "Side A", "value": "PB 41447 A-1"},
+    {"type": "Matrix / Runout", "description": "Side B", "value": "PB 41447 B-1"}
+  ],
+  "images": [{"type": "primary", "uri": "https://img.discogs.com/a.jpg", "uri150": "https://img.discogs.com/a-150.jpg", "width": 600, "height": 600}],
+  "videos": [{"duration": 213, "embed": true, "title": "Rick Astley - Never Gonna Give You Up", "uri": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}],
+  "tracklist": [
+    {"position": "", "type_": "heading", "title": "Side A", "duration": ""},
+    {"position": "A", "type_": "track", "title": "Never Gonna Give You Up", "duration": "3:32",
+     "extraartists": [{"name": "Rick Astley (2)", "anv": "", "role": "Vocals"}]},
+    {"position": "", "type_": "heading", "title": "Side B", "duration": ""},
+    {"position": "", "type_": "index", "title": "Suite", "duration": "",
+     "sub_tracks": [
+       {"position": "B1a", "type_": "track", "title": "Part One", "duration": "1:30"},
+       {"position": "B1b", "type_": "track", "title": "Part Two", "duration": "2:00"}
+     ]},
+    {"position": "", "type_": "index", "title": "Medley", "duration": "",
+     "sub_tracks": [
+       {"position": "B2", "type_": "track", "title": "First Song", "duration": "3:00"},
+       {"position": "B3", "type_": "track", "title": "Second Song", "duration": "1:01:00"}
+     ]}
+  ]
+}`
+
+func TestDiscogsReleaseDetails(t *testing.T) {
+	fmt.Println("+ Testing Discogs release details...")
+	check := assert.New(t)
+
+	d := NewDiscogsRelease("", "")
+	check.NotNil(d.GetRelease(0))
+	require.Nil(t, json.Unmarshal([]byte(testDiscogsReleaseJSON), &d.Details))
+
+	r := d.Details
+	check.Equal(249504, r.ID)
+	check.Equal(96559, r.MasterID)
+	check.Equal("Rick Astley (2) & The Band", r.Artists.String())
+	check.Equal("Producer", r.ExtraArtists[0].Role)
+	check.Equal("PB 41447", r.Labels[0].Catno)
+	check.Equal([]string{"7\"", "Single", "45 RPM"}, r.Formats[0].Descriptions)
+	check.Equal("5012394144777", r.Barcode())
+	check.Equal([]string{"PB 41447 A-1", "PB 41447 B-1"}, r.IdentifierValues("matrix / runout"))
+	check.Equal(600, r.Images[0].Width)
+	check.Equal(213, r.Videos[0].Duration)
+	check.Equal(5, len(r.Tracklist))
+
+	tracks := r.Tracks()
+	require.Equal(t, 4, len(tracks))
+	check.Equal("A", tracks[0].Position)
+	check.Equal(212*time.Second, tracks[0].Length())
+	check.Equal("Vocals", tracks[0].ExtraArtists[0].Role)
+	// sub tracks of a single track are merged
+	check.Equal("B1", tracks[1].Position)
+	check.Equal("Suite", tracks[1].Title)
+	check.Equal("3:30", tracks[1].Duration)
+	check.Equal(0, len(tracks[1].SubTracks))
+	// complete tracks are expanded
+	check.Equal("B2", tracks[2].Position)
+	check.Equal("First Song", tracks[2].Title)
+	check.Equal("B3", tracks[3].Position)
+	check.Equal(time.Hour+time.Minute, tracks[3].Length())
+
+	check.Equal(time.Duration(0), parseDiscogsDuration(""))
+	check.Equal(time.Duration(0), parseDiscogsDuration("?"))
+	check.Equal(discogsPosition{Track: "3", Part: "b"}, parseDiscogsPosition("3.b", false))
+	check.Equal(discogsPosition{Track: "CD1-3", Part: "1", Disc: 1}, parseDiscogsPosition("CD1-3.1", true))
+	check.Equal(discogsPosition{Track: "A2", Side: "A"}, parseDiscogsPosition("A2", false))
+	check.Equal(discogsPosition{Track: "B1", Part: "a", Side: "B"}, parseDiscogsPosition("B1a", false))
+	// "N.M" is a part of track N in index tracks, track M of disc N elsewhere
+	check.Equal(discogsPosition{Track: "3", Part: "1"}, parseDiscogsPosition("3.1", true))
+	check.Equal(discogsPosition{Track: "2.5", Disc: 2}, parseDiscogsPosition("2.5", false))
+
+	// entries without position or duration are kept, only headings are skipped
+	r.Tracklist = append(r.Tracklist, DiscogsTrack{Type: DiscogsTrackType, Title: "Hidden Track"})
+	tracks = r.Tracks()
+	require.Equal(t, 5, len(tracks))
+	check.Equal("Hidden Track", tracks[4].Title)
+}
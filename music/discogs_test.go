@@ -2,7 +2,9 @@ package music
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -16,23 +18,32 @@ func TestDiscogs(t *testing.T) {
 	fmt.Println("+ Testing Discogs...")
 	check := assert.New(t)
 	ui := &u.UI{}
+	options := testFixtureOptions(t)
 
-	// get api key from env
+	// get api key and secret from env, and user credentials from
+	// discogs_credentials.json, only needed to record fixtures
 	key := os.Getenv("DISCOGS_TOKEN")
-	require.NotEqual(t, 0, len(key), "Cannot get Discogs application token")
-	// get api secret from env
 	secret := os.Getenv("DISCOGS_SECRET")
-	require.NotEqual(t, 0, len(key), "Cannot get Discogs application secret")
+	credentials := credentialsFile
+	if *recordFixtures {
+		require.NotEqual(t, 0, len(key), "Cannot get Discogs application token")
+		require.NotEqual(t, 0, len(secret), "Cannot get Discogs application secret")
+		_, err := os.Stat(credentials)
+		require.Nil(t, err, "Cannot get Discogs user credentials")
+	} else {
+		key, secret = "replay", "replay"
+		dir, err := ioutil.TempDir("", "aubergine")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+		credentials = filepath.Join(dir, credentialsFile)
+		require.Nil(t, ioutil.WriteFile(credentials, []byte(`{"Token": "replay", "Secret": "replay"}`), 0600))
+	}
 
 	for _, t := range testMBReleases {
 		fmt.Println("Testing with " + t.artist + " - " + t.albumTitle)
 		a := NewDiscogsRelease(key, secret)
-
-		if err := a.readCredentials(); err != nil {
-			fmt.Println("COULD NOT TEST, CREDENTIALS MISSING")
-			fmt.Println("For now, this test requires a valid discogs_credentials.json file, obviously not included in the repository.")
-			return
-		}
+		a.Options = options
+		a.CredentialsFile = credentials
 
 		err := a.Authorize(ui)
 		check.Nil(err, "Error authorizing")
@@ -49,14 +60,27 @@ func TestDiscogs(t *testing.T) {
 		catno := rp.Replace(t.expectedCatalogNumber)
 
 		found := false
+		releaseID := 0
 		for _, r := range a.Info.Results {
 			_, knownLabel := helpers.StringInSlice(t.expectedLabel, r.Label)
 			if knownLabel && catno == rp.Replace(r.Catno) {
 				found = true
+				releaseID = r.ID
 				break
 			}
 		}
 		check.Equal(true, found, "Release was not found on Discogs!")
+
+		err = a.GetRelease(releaseID)
+		check.Nil(err, "Error getting Discogs release")
+		check.Equal(releaseID, a.Details.ID)
+		check.NotEqual(0, len(a.Details.Tracks()), "Expected a tracklist")
+
+		err = a.GetMaster(a.Details.MasterID)
+		check.Nil(err, "Error getting Discogs master release")
+		err = a.GetVersions(a.Master.ID)
+		check.Nil(err, "Error getting Discogs master release versions")
+		check.NotEqual(0, len(a.FilterVersions("", "", t.expectedLabel, t.expectedCatalogNumber, 0)), "Expected to find the release among versions")
 		/*
 			for _, r := range a.Info.Results {
 				fmt.Println(r.ID, r.Title, r.Year, r.Country, r.Format, r.Genre, r.Label, r.Catno)
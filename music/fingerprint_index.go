@@ -0,0 +1,272 @@
+package music
+
+import (
+	"context"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// fingerprintKeyShift drops the last classifiers from the index keys,
+	// so that slightly different copies still share keys.
+	fingerprintKeyShift = 12
+	// fingerprintCandidateOffsets checked for each candidate, by number of votes.
+	fingerprintCandidateOffsets = 3
+	// fingerprintMinVotes at the same offset for a candidate to be checked.
+	fingerprintMinVotes = 2
+)
+
+// FingerprintDuplicateSimilarity above which two recordings are considered the same.
+// Unrelated recordings are around 0.5.
+var FingerprintDuplicateSimilarity = 0.85
+
+// AudioExtensions fingerprinted by IndexLibrary; all but FLAC need fpcalc.
+var AudioExtensions = []string{flacExtension, ".mp3", ".ogg", ".opus", ".m4a", ".wav"}
+
+// FingerprintSimilarity between two raw fingerprints, as the proportion of
+// identical bits where they overlap, for the best alignment within maxOffset.
+// b[i] is aligned with a[i+offset]. At least half of the shorter fingerprint must overlap.
+func FingerprintSimilarity(a, b []uint32, maxOffset int) (float64, int) {
+	best, bestOffset := 0.0, 0
+	for offset := -maxOffset; offset <= maxOffset; offset++ {
+		if s, ok := alignedSimilarity(a, b, offset); ok && s > best {
+			best, bestOffset = s, offset
+		}
+	}
+	return best, bestOffset
+}
+
+// alignedSimilarity of two raw fingerprints, with b[i] aligned with a[i+offset].
+func alignedSimilarity(a, b []uint32, offset int) (float64, bool) {
+	start, end := 0, len(b)
+	if offset < 0 {
+		start = -offset
+	}
+	if len(a)-offset < end {
+		end = len(a) - offset
+	}
+	shortest := len(a)
+	if len(b) < shortest {
+		shortest = len(b)
+	}
+	if end-start == 0 || 2*(end-start) < shortest {
+		return 0, false
+	}
+	errors := 0
+	for i := start; i < end; i++ {
+		errors += bits.OnesCount32(a[i+offset] ^ b[i])
+	}
+	return 1 - float64(errors)/float64(32*(end-start)), true
+}
+
+// FingerprintMatch is a recording similar to a searched fingerprint.
+type FingerprintMatch struct {
+	ID         string
+	Similarity float64
+	Offset     int // query[i] is aligned with the match fingerprint at i+Offset
+}
+
+// FingerprintDuplicate is a pair of similar recordings in an index.
+type FingerprintDuplicate struct {
+	A, B       string
+	Similarity float64
+	Offset     int // A[i] is aligned with B[i+Offset]
+}
+
+type fingerprintPosting struct {
+	entry, position int32
+}
+
+// FingerprintIndex finds similar recordings from their raw fingerprints, offline.
+// Candidates share index keys at a consistent offset, then are compared bit by bit.
+type FingerprintIndex struct {
+	ids      []string
+	raws     [][]uint32
+	postings map[uint32][]fingerprintPosting
+}
+
+// NewFingerprintIndex creates an empty index.
+func NewFingerprintIndex() *FingerprintIndex {
+	return &FingerprintIndex{postings: map[uint32][]fingerprintPosting{}}
+}
+
+// Len of the index, in recordings.
+func (fi *FingerprintIndex) Len() int {
+	return len(fi.ids)
+}
+
+// Add the raw fingerprint of a recording.
+func (fi *FingerprintIndex) Add(id string, raw []uint32) {
+	entry := int32(len(fi.ids))
+	fi.ids = append(fi.ids, id)
+	fi.raws = append(fi.raws, raw)
+	for i, v := range raw {
+		key := v >> fingerprintKeyShift
+		fi.postings[key] = append(fi.postings[key], fingerprintPosting{entry, int32(i)})
+	}
+}
+
+// Search recordings at least as similar as threshold to a raw fingerprint, most similar first.
+func (fi *FingerprintIndex) Search(raw []uint32, threshold float64) []FingerprintMatch {
+	matches := []FingerprintMatch{}
+	for entry, offset := range fi.search(raw, threshold) {
+		matches = append(matches, FingerprintMatch{ID: fi.ids[entry], Similarity: offset.similarity, Offset: offset.offset})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+type fingerprintAlignment struct {
+	similarity float64
+	offset     int
+}
+
+// search returns the best alignment of each similar entry.
+func (fi *FingerprintIndex) search(raw []uint32, threshold float64) map[int32]fingerprintAlignment {
+	votes := map[int32]map[int]int{}
+	for i, v := range raw {
+		for _, p := range fi.postings[v>>fingerprintKeyShift] {
+			if votes[p.entry] == nil {
+				votes[p.entry] = map[int]int{}
+			}
+			votes[p.entry][int(p.position)-i]++
+		}
+	}
+	found := map[int32]fingerprintAlignment{}
+	for entry, offsets := range votes {
+		candidates := []int{}
+		for offset, n := range offsets {
+			if n >= fingerprintMinVotes {
+				candidates = append(candidates, offset)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if offsets[candidates[i]] != offsets[candidates[j]] {
+				return offsets[candidates[i]] > offsets[candidates[j]]
+			}
+			return candidates[i] < candidates[j]
+		})
+		if len(candidates) > fingerprintCandidateOffsets {
+			candidates = candidates[:fingerprintCandidateOffsets]
+		}
+		best := fingerprintAlignment{}
+		for _, offset := range candidates {
+			if s, ok := alignedSimilarity(fi.raws[entry], raw, offset); ok && s > best.similarity {
+				best = fingerprintAlignment{s, offset}
+			}
+		}
+		if best.similarity >= threshold && best.similarity > 0 {
+			found[entry] = best
+		}
+	}
+	return found
+}
+
+// Duplicates finds all pairs of recordings at least as similar as threshold, most similar first.
+func (fi *FingerprintIndex) Duplicates(threshold float64) []FingerprintDuplicate {
+	duplicates := []FingerprintDuplicate{}
+	for i, raw := range fi.raws {
+		for entry, a := range fi.search(raw, threshold) {
+			if int(entry) <= i {
+				continue
+			}
+			duplicates = append(duplicates, FingerprintDuplicate{A: fi.ids[i], B: fi.ids[entry], Similarity: a.similarity, Offset: a.offset})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Similarity != duplicates[j].Similarity {
+			return duplicates[i].Similarity > duplicates[j].Similarity
+		}
+		if duplicates[i].A != duplicates[j].A {
+			return duplicates[i].A < duplicates[j].A
+		}
+		return duplicates[i].B < duplicates[j].B
+	})
+	return duplicates
+}
+
+// IndexFingerprints of audio files, in parallel with a number of workers (one per CPU if 0).
+// Files that could not be fingerprinted are returned with their error.
+func IndexFingerprints(paths []string, workers int) (*FingerprintIndex, map[string]error) {
+	return IndexFingerprintsContext(context.Background(), paths, workers)
+}
+
+// IndexFingerprintsContext of audio files, until the context is done.
+// Files that were not fingerprinted by then are returned with the context error.
+func IndexFingerprintsContext(ctx context.Context, paths []string, workers int) (*FingerprintIndex, map[string]error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	raws := make([][]uint32, len(paths))
+	errs := make([]error, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				a := &AcousticID{}
+				errs[i] = a.CalculateFingerprintContext(ctx, paths[i])
+				raws[i] = a.RawFingerprint
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	index := NewFingerprintIndex()
+	failed := map[string]error{}
+	for i, path := range paths {
+		if errs[i] != nil {
+			failed[path] = errs[i]
+			continue
+		}
+		index.Add(path, raws[i])
+	}
+	return index, failed
+}
+
+// IndexLibrary fingerprints all audio files found under root.
+func IndexLibrary(root string, workers int) (*FingerprintIndex, map[string]error, error) {
+	return IndexLibraryContext(context.Background(), root, workers)
+}
+
+// IndexLibraryContext fingerprints all audio files found under root, until the context is done.
+func IndexLibraryContext(ctx context.Context, root string, workers int) (*FingerprintIndex, map[string]error, error) {
+	paths := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		extension := strings.ToLower(filepath.Ext(path))
+		for _, e := range AudioExtensions {
+			if extension == e {
+				paths = append(paths, path)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	index, failed := IndexFingerprintsContext(ctx, paths, workers)
+	return index, failed, nil
+}
@@ -0,0 +1,118 @@
+package music
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintSimilarity(t *testing.T) {
+	fmt.Println("+ Testing fingerprint similarity...")
+	check := assert.New(t)
+
+	music := testMusic(30, 11025, 1, 1)
+	raw := testFingerprint(music, 11025, 1)
+	trimmed := testFingerprint(music[16*chromaprintHop:], 11025, 1)
+	other := testFingerprint(testMusic(30, 11025, 1, 2), 11025, 1)
+
+	s, offset := FingerprintSimilarity(raw, raw, 10)
+	check.Equal(1.0, s)
+	check.Equal(0, offset)
+	s, offset = FingerprintSimilarity(raw, trimmed, 30)
+	check.Equal(1.0, s)
+	check.Equal(16, offset)
+	s, offset = FingerprintSimilarity(trimmed, raw, 30)
+	check.Equal(1.0, s)
+	check.Equal(-16, offset)
+	s, _ = FingerprintSimilarity(raw, trimmed, 10)
+	check.True(s < FingerprintDuplicateSimilarity, "Out of reach: %v", s)
+	s, _ = FingerprintSimilarity(raw, other, 30)
+	check.True(s < 0.75, "Different music: %v", s)
+
+	// at least half of the shortest must overlap
+	s, ok := alignedSimilarity(raw[:100], raw[40:140], 40)
+	check.True(ok)
+	check.Equal(1.0, s)
+	_, ok = alignedSimilarity(raw[:100], raw[60:160], 60)
+	check.False(ok)
+	s, _ = FingerprintSimilarity(raw, []uint32{}, 10)
+	check.Equal(0.0, s)
+}
+
+func TestFingerprintIndex(t *testing.T) {
+	fmt.Println("+ Testing fingerprint index...")
+	check := assert.New(t)
+
+	music := testMusic(30, 11025, 1, 1)
+	raw := testFingerprint(music, 11025, 1)
+	index := NewFingerprintIndex()
+	index.Add("original", raw)
+	index.Add("resampled", testFingerprint(testMusic(30, 44100, 2, 1), 44100, 2))
+	index.Add("trimmed", testFingerprint(music[16*chromaprintHop+600:], 11025, 1))
+	index.Add("other", testFingerprint(testMusic(30, 11025, 1, 2), 11025, 1))
+	index.Add("empty", []uint32{})
+	check.Equal(5, index.Len())
+
+	matches := index.Search(raw, FingerprintDuplicateSimilarity)
+	require.Equal(t, 3, len(matches))
+	check.Equal(FingerprintMatch{ID: "original", Similarity: 1, Offset: 0}, matches[0])
+	check.Equal("resampled", matches[1].ID)
+	check.Equal(0, matches[1].Offset)
+	check.Equal("trimmed", matches[2].ID)
+	check.InDelta(-16, matches[2].Offset, 1)
+	check.Equal(0, len(index.Search([]uint32{}, 0)))
+
+	duplicates := index.Duplicates(FingerprintDuplicateSimilarity)
+	require.Equal(t, 3, len(duplicates))
+	check.Equal("original", duplicates[0].A)
+	check.Equal("resampled", duplicates[0].B)
+	check.Equal("resampled", duplicates[2].A)
+	check.Equal("trimmed", duplicates[2].B)
+	for _, d := range duplicates {
+		check.True(d.Similarity >= FingerprintDuplicateSimilarity)
+	}
+	check.Equal(0, len(index.Duplicates(1.0)))
+}
+
+func TestIndexLibrary(t *testing.T) {
+	fmt.Println("+ Testing library fingerprint index...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "copy"), 0755))
+
+	channels := func(music []int16) [][]int32 {
+		left, right := make([]int32, len(music)/2), make([]int32, len(music)/2)
+		for i := range left {
+			left[i], right[i] = int32(music[2*i]), int32(music[2*i+1])
+		}
+		return [][]int32{left, right}
+	}
+	writeTestAudioFlac(t, filepath.Join(dir, "01.flac"), 44100, channels(testMusic(12, 44100, 2, 5)))
+	writeTestAudioFlac(t, filepath.Join(dir, "copy", "01.FLAC"), 22050, channels(testMusic(12, 22050, 2, 5)))
+	writeTestAudioFlac(t, filepath.Join(dir, "02.flac"), 44100, channels(testMusic(12, 44100, 2, 6)))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "broken.flac"), []byte("fLaC"), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "cover.jpg"), []byte("jpeg"), 0644))
+
+	index, failed, err := IndexLibrary(dir, 2)
+	require.Nil(t, err)
+	check.Equal(3, index.Len())
+	require.Equal(t, 1, len(failed))
+	check.NotNil(failed[filepath.Join(dir, "broken.flac")])
+
+	duplicates := index.Duplicates(FingerprintDuplicateSimilarity)
+	require.Equal(t, 1, len(duplicates))
+	check.Equal(filepath.Join(dir, "01.flac"), duplicates[0].A)
+	check.Equal(filepath.Join(dir, "copy", "01.FLAC"), duplicates[0].B)
+	check.Equal(0, duplicates[0].Offset)
+
+	_, _, err = IndexLibrary(filepath.Join(dir, "missing"), 0)
+	check.NotNil(err)
+}
@@ -0,0 +1,451 @@
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	flacMagic           = "fLaC"
+	flacBlockHeaderSize = 4
+)
+
+// FlacBlockType identifies a FLAC metadata block.
+type FlacBlockType uint8
+
+// FLAC metadata block types, as defined in the format specification.
+const (
+	FlacStreamInfoBlock    FlacBlockType = 0
+	FlacPaddingBlock       FlacBlockType = 1
+	FlacApplicationBlock   FlacBlockType = 2
+	FlacSeekTableBlock     FlacBlockType = 3
+	FlacVorbisCommentBlock FlacBlockType = 4
+	FlacCueSheetBlock      FlacBlockType = 5
+	FlacPictureBlock       FlacBlockType = 6
+)
+
+// String representation of the block type.
+func (t FlacBlockType) String() string {
+	switch t {
+	case FlacStreamInfoBlock:
+		return "STREAMINFO"
+	case FlacPaddingBlock:
+		return "PADDING"
+	case FlacApplicationBlock:
+		return "APPLICATION"
+	case FlacSeekTableBlock:
+		return "SEEKTABLE"
+	case FlacVorbisCommentBlock:
+		return "VORBIS_COMMENT"
+	case FlacCueSheetBlock:
+		return "CUESHEET"
+	case FlacPictureBlock:
+		return "PICTURE"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(t))
+}
+
+// FlacMetadataBlock is a raw metadata block, as found in the file.
+type FlacMetadataBlock struct {
+	Type   FlacBlockType
+	Offset int64 // position of the block header in the file
+	Data   []byte
+}
+
+// FlacStreamInfo describes the audio stream.
+type FlacStreamInfo struct {
+	MinBlockSize  uint16
+	MaxBlockSize  uint16
+	MinFrameSize  uint32
+	MaxFrameSize  uint32
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	TotalSamples  uint64
+	MD5           [16]byte
+}
+
+// Duration of the stream, if the total number of samples is known.
+func (s FlacStreamInfo) Duration() time.Duration {
+	if s.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(s.TotalSamples) * time.Second / time.Duration(s.SampleRate)
+}
+
+// FlacTag is a single Vorbis comment field.
+type FlacTag struct {
+	Name  string
+	Value string
+}
+
+// FlacVorbisComment holds the tags of a FLAC file, in file order.
+type FlacVorbisComment struct {
+	Vendor string
+	Tags   []FlacTag
+}
+
+// Get all values for a field name, case-insensitive.
+func (vc *FlacVorbisComment) Get(name string) []string {
+	values := []string{}
+	for _, t := range vc.Tags {
+		if strings.EqualFold(t.Name, name) {
+			values = append(values, t.Value)
+		}
+	}
+	return values
+}
+
+// GetFirst value for a field name, or an empty string.
+func (vc *FlacVorbisComment) GetFirst(name string) string {
+	if values := vc.Get(name); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// FlacPicture is an embedded image.
+type FlacPicture struct {
+	Type        uint32
+	MIME        string
+	Description string
+	Width       uint32
+	Height      uint32
+	Depth       uint32
+	Colors      uint32
+	Data        []byte
+}
+
+// FlacSeekPoint is a SEEKTABLE entry.
+type FlacSeekPoint struct {
+	SampleNumber uint64
+	Offset       uint64
+	Samples      uint16
+}
+
+// IsPlaceholder seek point, which must be ignored.
+func (p FlacSeekPoint) IsPlaceholder() bool {
+	return p.SampleNumber == 0xFFFFFFFFFFFFFFFF
+}
+
+// FlacApplication is an APPLICATION block, identified by a registered ID.
+type FlacApplication struct {
+	ID   string
+	Data []byte
+}
+
+// FlacCueSheetIndex is an index point of a CUESHEET track.
+type FlacCueSheetIndex struct {
+	Offset uint64
+	Number uint8
+}
+
+// FlacCueSheetTrack is a CUESHEET track.
+type FlacCueSheetTrack struct {
+	Offset      uint64
+	Number      uint8
+	ISRC        string
+	IsAudio     bool
+	PreEmphasis bool
+	Indexes     []FlacCueSheetIndex
+}
+
+// FlacCueSheet describes the layout of the original CD, if any.
+type FlacCueSheet struct {
+	MediaCatalogNumber string
+	LeadInSamples      uint64
+	IsCD               bool
+	Tracks             []FlacCueSheetTrack
+}
+
+// FlacFile is the parsed metadata of a FLAC file.
+type FlacFile struct {
+	Path         string
+	StreamInfo   FlacStreamInfo
+	Comments     *FlacVorbisComment
+	Pictures     []FlacPicture
+	SeekTable    []FlacSeekPoint
+	Applications []FlacApplication
+	CueSheet     *FlacCueSheet
+	Padding      int
+	Blocks       []FlacMetadataBlock
+	AudioOffset  int64 // position of the first audio frame in the file
+}
+
+// ReadFlac metadata from a file.
+func ReadFlac(path string) (*FlacFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	flac, err := ParseFlac(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	flac.Path = path
+	return flac, nil
+}
+
+// ParseFlac metadata from a reader, stopping at the first audio frame.
+func ParseFlac(r io.Reader) (*FlacFile, error) {
+	offset, err := skipID3v2(r)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(flacMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, errors.New("Not a FLAC file")
+	}
+	if string(magic) != flacMagic {
+		return nil, errors.New("Not a FLAC file")
+	}
+	offset += int64(len(flacMagic))
+
+	flac := &FlacFile{}
+	header := make([]byte, flacBlockHeaderSize)
+	for last := false; !last; {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, errors.New("Truncated metadata block header")
+		}
+		last = header[0]&0x80 != 0
+		block := FlacMetadataBlock{
+			Type:   FlacBlockType(header[0] & 0x7F),
+			Offset: offset,
+			Data:   make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3])),
+		}
+		if _, err := io.ReadFull(r, block.Data); err != nil {
+			return nil, fmt.Errorf("Truncated %s block", block.Type)
+		}
+		if len(flac.Blocks) == 0 && block.Type != FlacStreamInfoBlock {
+			return nil, errors.New("First metadata block must be STREAMINFO")
+		}
+		if err := flac.parseBlock(block); err != nil {
+			return nil, err
+		}
+		flac.Blocks = append(flac.Blocks, block)
+		offset += int64(flacBlockHeaderSize + len(block.Data))
+	}
+	flac.AudioOffset = offset
+	return flac, nil
+}
+
+// skipID3v2 tag some taggers insist on prepending to FLAC files.
+func skipID3v2(r io.Reader) (int64, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		return 0, nil
+	}
+	header, err := br.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+	// syncsafe integer, plus optional footer
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	if _, err := io.CopyN(ioutil.Discard, br, size); err != nil {
+		return 0, errors.New("Truncated ID3v2 tag")
+	}
+	return size, nil
+}
+
+func (f *FlacFile) parseBlock(block FlacMetadataBlock) error {
+	var err error
+	switch block.Type {
+	case FlacStreamInfoBlock:
+		f.StreamInfo, err = parseStreamInfo(block.Data)
+	case FlacPaddingBlock:
+		f.Padding += len(block.Data)
+	case FlacApplicationBlock:
+		if len(block.Data) < 4 {
+			return errors.New("Invalid APPLICATION block")
+		}
+		f.Applications = append(f.Applications, FlacApplication{ID: string(block.Data[:4]), Data: block.Data[4:]})
+	case FlacSeekTableBlock:
+		f.SeekTable, err = parseSeekTable(block.Data)
+	case FlacVorbisCommentBlock:
+		f.Comments, err = parseVorbisComment(block.Data)
+	case FlacCueSheetBlock:
+		f.CueSheet, err = parseCueSheet(block.Data)
+	case FlacPictureBlock:
+		var p *FlacPicture
+		if p, err = parsePicture(block.Data); err == nil {
+			f.Pictures = append(f.Pictures, *p)
+		}
+	}
+	return err
+}
+
+func parseStreamInfo(data []byte) (FlacStreamInfo, error) {
+	si := FlacStreamInfo{}
+	if len(data) != 34 {
+		return si, errors.New("Invalid STREAMINFO block")
+	}
+	si.MinBlockSize = binary.BigEndian.Uint16(data[0:2])
+	si.MaxBlockSize = binary.BigEndian.Uint16(data[2:4])
+	si.MinFrameSize = uint32(data[4])<<16 | uint32(data[5])<<8 | uint32(data[6])
+	si.MaxFrameSize = uint32(data[7])<<16 | uint32(data[8])<<8 | uint32(data[9])
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bps-1, 36 bits total samples
+	packed := binary.BigEndian.Uint64(data[10:18])
+	si.SampleRate = uint32(packed >> 44)
+	si.Channels = uint8(packed>>41&0x07) + 1
+	si.BitsPerSample = uint8(packed>>36&0x1F) + 1
+	si.TotalSamples = packed & 0xFFFFFFFFF
+	copy(si.MD5[:], data[18:34])
+	if si.SampleRate == 0 {
+		return si, errors.New("Invalid sample rate in STREAMINFO")
+	}
+	return si, nil
+}
+
+func parseSeekTable(data []byte) ([]FlacSeekPoint, error) {
+	if len(data)%18 != 0 {
+		return nil, errors.New("Invalid SEEKTABLE block")
+	}
+	points := make([]FlacSeekPoint, len(data)/18)
+	for i := range points {
+		p := data[i*18:]
+		points[i] = FlacSeekPoint{
+			SampleNumber: binary.BigEndian.Uint64(p[0:8]),
+			Offset:       binary.BigEndian.Uint64(p[8:16]),
+			Samples:      binary.BigEndian.Uint16(p[16:18]),
+		}
+	}
+	return points, nil
+}
+
+func parseVorbisComment(data []byte) (*FlacVorbisComment, error) {
+	// unlike the rest of FLAC, vorbis comments are little-endian
+	r := bytes.NewReader(data)
+	readString := func() (string, error) {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return "", err
+		}
+		if int64(length) > int64(r.Len()) {
+			return "", io.ErrUnexpectedEOF
+		}
+		s := make([]byte, length)
+		_, err := io.ReadFull(r, s)
+		return string(s), err
+	}
+	vendor, err := readString()
+	if err != nil {
+		return nil, errors.New("Invalid VORBIS_COMMENT block")
+	}
+	vc := &FlacVorbisComment{Vendor: vendor}
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, errors.New("Invalid VORBIS_COMMENT block")
+	}
+	for i := uint32(0); i < count; i++ {
+		comment, err := readString()
+		if err != nil {
+			return nil, errors.New("Invalid VORBIS_COMMENT block")
+		}
+		parts := strings.SplitN(comment, "=", 2)
+		if len(parts) != 2 {
+			// ignoring malformed comments rather than rejecting the file
+			continue
+		}
+		vc.Tags = append(vc.Tags, FlacTag{Name: parts[0], Value: parts[1]})
+	}
+	return vc, nil
+}
+
+func parsePicture(data []byte) (*FlacPicture, error) {
+	invalid := errors.New("Invalid PICTURE block")
+	r := bytes.NewReader(data)
+	readUint32 := func() (uint32, error) {
+		var v uint32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	}
+	readBytes := func() ([]byte, error) {
+		length, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if int64(length) > int64(r.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, length)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+	pictureType, err := readUint32()
+	if err != nil {
+		return nil, invalid
+	}
+	p := &FlacPicture{Type: pictureType}
+	mime, err := readBytes()
+	if err != nil {
+		return nil, invalid
+	}
+	description, err := readBytes()
+	if err != nil {
+		return nil, invalid
+	}
+	p.MIME = string(mime)
+	p.Description = string(description)
+	for _, v := range []*uint32{&p.Width, &p.Height, &p.Depth, &p.Colors} {
+		if *v, err = readUint32(); err != nil {
+			return nil, invalid
+		}
+	}
+	if p.Data, err = readBytes(); err != nil {
+		return nil, invalid
+	}
+	return p, nil
+}
+
+func parseCueSheet(data []byte) (*FlacCueSheet, error) {
+	invalid := errors.New("Invalid CUESHEET block")
+	if len(data) < 396 {
+		return nil, invalid
+	}
+	cs := &FlacCueSheet{
+		MediaCatalogNumber: strings.TrimRight(string(data[0:128]), "\x00"),
+		LeadInSamples:      binary.BigEndian.Uint64(data[128:136]),
+		IsCD:               data[136]&0x80 != 0,
+	}
+	numTracks := int(data[395])
+	pos := 396
+	for i := 0; i < numTracks; i++ {
+		if len(data) < pos+36 {
+			return nil, invalid
+		}
+		t := FlacCueSheetTrack{
+			Offset:      binary.BigEndian.Uint64(data[pos : pos+8]),
+			Number:      data[pos+8],
+			ISRC:        strings.TrimRight(string(data[pos+9:pos+21]), "\x00"),
+			IsAudio:     data[pos+21]&0x80 == 0,
+			PreEmphasis: data[pos+21]&0x40 != 0,
+		}
+		numIndexes := int(data[pos+35])
+		pos += 36
+		for j := 0; j < numIndexes; j++ {
+			if len(data) < pos+12 {
+				return nil, invalid
+			}
+			t.Indexes = append(t.Indexes, FlacCueSheetIndex{
+				Offset: binary.BigEndian.Uint64(data[pos : pos+8]),
+				Number: data[pos+8],
+			})
+			pos += 12
+		}
+		cs.Tracks = append(cs.Tracks, t)
+	}
+	return cs, nil
+}
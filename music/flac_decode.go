@@ -0,0 +1,642 @@
+package music
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+)
+
+const (
+	flacFrameSync = 0x7FFC // 14 bits sync code and reserved bit
+	flacCRC8Poly  = 0x07
+	flacCRC16Poly = 0x8005
+)
+
+// FLAC frame errors.
+var (
+	ErrFlacHeaderCRC = errors.New("FLAC frame header CRC mismatch")
+	ErrFlacFrameCRC  = errors.New("FLAC frame CRC mismatch")
+)
+
+var (
+	flacCRC8Table  = makeCRC8Table(flacCRC8Poly)
+	flacCRC16Table = makeCRC16Table(flacCRC16Poly)
+)
+
+func makeCRC8Table(poly uint8) [256]uint8 {
+	table := [256]uint8{}
+	for i := range table {
+		crc := uint8(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func makeCRC16Table(poly uint16) [256]uint16 {
+	table := [256]uint16{}
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// flacCRC8 of data, as used in frame headers.
+func flacCRC8(data []byte) uint8 {
+	crc := uint8(0)
+	for _, b := range data {
+		crc = flacCRC8Table[crc^b]
+	}
+	return crc
+}
+
+// flacCRC16 of data, as used for whole frames.
+func flacCRC16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc = crc<<8 ^ flacCRC16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// FLAC channel assignments, for stereo decorrelation.
+const (
+	flacLeftSide  = 8
+	flacSideRight = 9
+	flacMidSide   = 10
+)
+
+// FlacFrame of decoded audio.
+type FlacFrame struct {
+	SampleRate    uint32
+	BitsPerSample uint8
+	// Number of the frame, or of its first sample for variable block sizes.
+	Number  uint64
+	Offset  int64     // position of the frame in the file
+	Samples [][]int32 // one slice per channel
+}
+
+// FlacDecoder reads the audio frames of a FLAC stream.
+type FlacDecoder struct {
+	Info    FlacStreamInfo
+	r       *flacBitReader
+	file    *os.File
+	decoded uint64
+	// positions of the audio and of the last frame read in the file
+	audioOffset int64
+	frameOffset int64
+	pcm         []byte
+	pending     []byte // PCM not read yet
+}
+
+// OpenFlacDecoder for a FLAC file. It must be closed after use.
+func OpenFlacDecoder(path string) (*FlacDecoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewFlacDecoder(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	d.file = f
+	return d, nil
+}
+
+// NewFlacDecoder reading metadata, then frames, from r.
+func NewFlacDecoder(r io.Reader) (*FlacDecoder, error) {
+	br := bufio.NewReader(r)
+	flac, err := ParseFlac(br)
+	if err != nil {
+		return nil, err
+	}
+	if flac.StreamInfo.BitsPerSample > 32 || flac.StreamInfo.BitsPerSample < 4 {
+		return nil, errors.New("Unsupported bits per sample")
+	}
+	return &FlacDecoder{Info: flac.StreamInfo, r: &flacBitReader{r: br}, audioOffset: flac.AudioOffset, frameOffset: flac.AudioOffset}, nil
+}
+
+// Offset in the file of the last frame read, or being read when an error occurred.
+func (d *FlacDecoder) Offset() int64 {
+	return d.frameOffset
+}
+
+// Close the underlying file, if opened by the decoder.
+func (d *FlacDecoder) Close() error {
+	if d.file != nil {
+		return d.file.Close()
+	}
+	return nil
+}
+
+// ReadFrame decodes the next audio frame. It returns io.EOF at the end of the stream,
+// and an error wrapping io.ErrUnexpectedEOF if the stream ends within a frame.
+func (d *FlacDecoder) ReadFrame() (*FlacFrame, error) {
+	if d.Info.TotalSamples != 0 && d.decoded >= d.Info.TotalSamples {
+		// ignore trailing data, such as ID3v1 tags
+		return nil, io.EOF
+	}
+	frame, err := d.readFrame()
+	if err == io.EOF {
+		// the stream only ends cleanly if no byte of the frame was read
+		if d.audioOffset+d.r.read == d.frameOffset {
+			return nil, io.EOF
+		}
+		err = fmt.Errorf("Truncated FLAC frame at offset %d: %w", d.frameOffset, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.decoded += uint64(len(frame.Samples[0]))
+	return frame, nil
+}
+
+// Read decoded audio as interleaved little-endian signed PCM, with
+// (BitsPerSample+7)/8 bytes per sample, as hashed in STREAMINFO.
+// Unlike WAV files, 8-bit samples are signed too.
+func (d *FlacDecoder) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		frame, err := d.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		d.pcm = appendPCM(d.pcm[:0], frame.Samples, d.Info.BitsPerSample)
+		d.pending = d.pcm
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// appendPCM bytes of interleaved samples.
+func appendPCM(pcm []byte, samples [][]int32, bitsPerSample uint8) []byte {
+	size := (int(bitsPerSample) + 7) / 8
+	for i := range samples[0] {
+		for _, channel := range samples {
+			v := channel[i]
+			for b := 0; b < size; b++ {
+				pcm = append(pcm, byte(v>>uint(8*b)))
+			}
+		}
+	}
+	return pcm
+}
+
+func (d *FlacDecoder) readFrame() (*FlacFrame, error) {
+	r := d.r
+	r.crc8, r.crc16 = 0, 0
+	d.frameOffset = d.audioOffset + r.read - int64(r.n/8)
+	sync, err := r.readBits(16)
+	if err != nil {
+		return nil, err
+	}
+	if sync>>1 != flacFrameSync {
+		return nil, errors.New("Lost FLAC frame sync")
+	}
+	header, err := r.readBits(16)
+	if err != nil {
+		return nil, err
+	}
+	blockSizeCode, sampleRateCode := header>>12, header>>8&0xF
+	assignment, sampleSizeCode := int(header>>4&0xF), header>>1&0x7
+
+	frame := &FlacFrame{SampleRate: d.Info.SampleRate, BitsPerSample: d.Info.BitsPerSample, Offset: d.frameOffset}
+	if frame.Number, err = r.readUTF8(); err != nil {
+		return nil, err
+	}
+
+	blockSize := 0
+	switch {
+	case blockSizeCode == 0:
+		return nil, errors.New("Reserved FLAC block size")
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := r.readBits(8)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, err := r.readBits(16)
+		if err != nil {
+			return nil, err
+		}
+		blockSize = int(v) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+
+	switch sampleRateCode {
+	case 0:
+	case 12:
+		v, err := r.readBits(8)
+		if err != nil {
+			return nil, err
+		}
+		frame.SampleRate = uint32(v) * 1000
+	case 13, 14:
+		v, err := r.readBits(16)
+		if err != nil {
+			return nil, err
+		}
+		frame.SampleRate = uint32(v)
+		if sampleRateCode == 14 {
+			frame.SampleRate *= 10
+		}
+	case 15:
+		return nil, errors.New("Invalid FLAC sample rate")
+	default:
+		frame.SampleRate = []uint32{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}[sampleRateCode]
+	}
+
+	switch sampleSizeCode {
+	case 0:
+	case 3:
+		return nil, errors.New("Reserved FLAC sample size")
+	default:
+		frame.BitsPerSample = []uint8{0, 8, 12, 0, 16, 20, 24, 32}[sampleSizeCode]
+	}
+
+	channels := assignment + 1
+	if assignment > flacMidSide {
+		return nil, errors.New("Reserved FLAC channel assignment")
+	} else if assignment >= flacLeftSide {
+		channels = 2
+	}
+	headerCRC := r.crc8
+	if crc, err := r.readBits(8); err != nil {
+		return nil, err
+	} else if uint8(crc) != headerCRC {
+		return nil, ErrFlacHeaderCRC
+	}
+	if channels != int(d.Info.Channels) || frame.BitsPerSample != d.Info.BitsPerSample {
+		return nil, errors.New("FLAC frame and stream formats differ")
+	}
+
+	frame.Samples = make([][]int32, channels)
+	for c := range frame.Samples {
+		bps := uint(frame.BitsPerSample)
+		if (assignment == flacSideRight && c == 0) || ((assignment == flacLeftSide || assignment == flacMidSide) && c == 1) {
+			bps++
+		}
+		if bps > 32 {
+			return nil, errors.New("Unsupported 32-bit decorrelated stereo")
+		}
+		if frame.Samples[c], err = r.readSubframe(blockSize, bps); err != nil {
+			return nil, err
+		}
+	}
+	decorrelate(assignment, frame.Samples)
+
+	// zero padding, then CRC-16 of the frame
+	r.align()
+	frameCRC := r.crc16
+	if crc, err := r.readBits(16); err != nil {
+		return nil, err
+	} else if uint16(crc) != frameCRC {
+		return nil, ErrFlacFrameCRC
+	}
+	return frame, nil
+}
+
+// decorrelate stereo channels, in place.
+func decorrelate(assignment int, samples [][]int32) {
+	switch assignment {
+	case flacLeftSide:
+		for i, side := range samples[1] {
+			samples[1][i] = samples[0][i] - side
+		}
+	case flacSideRight:
+		for i, side := range samples[0] {
+			samples[0][i] = side + samples[1][i]
+		}
+	case flacMidSide:
+		for i, side := range samples[1] {
+			mid := int64(samples[0][i])<<1 | int64(side&1)
+			samples[0][i] = int32((mid + int64(side)) >> 1)
+			samples[1][i] = int32((mid - int64(side)) >> 1)
+		}
+	}
+}
+
+func (r *flacBitReader) readSubframe(blockSize int, bps uint) ([]int32, error) {
+	header, err := r.readBits(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errors.New("Invalid FLAC subframe header")
+	}
+	wasted := uint(0)
+	if header&1 == 1 {
+		zeros, err := r.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(zeros) + 1
+		if wasted >= bps {
+			return nil, errors.New("Invalid FLAC wasted bits")
+		}
+		bps -= wasted
+	}
+
+	samples := make([]int32, blockSize)
+	subframeType := header >> 1 & 0x3F
+	switch {
+	case subframeType == 0:
+		v, err := r.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = int32(v)
+		}
+	case subframeType == 1:
+		for i := range samples {
+			v, err := r.readSigned(bps)
+			if err != nil {
+				return nil, err
+			}
+			samples[i] = int32(v)
+		}
+	case subframeType >= 8 && subframeType <= 12:
+		if err := r.readFixed(samples, int(subframeType-8), bps); err != nil {
+			return nil, err
+		}
+	case subframeType >= 32:
+		if err := r.readLPC(samples, int(subframeType-31), bps); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Reserved FLAC subframe type")
+	}
+
+	if wasted != 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+func (r *flacBitReader) readWarmUp(samples []int32, order int, bps uint) error {
+	if order > len(samples) {
+		return errors.New("Invalid FLAC predictor order")
+	}
+	for i := 0; i < order; i++ {
+		v, err := r.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = int32(v)
+	}
+	return nil
+}
+
+// readFixed decodes a subframe using one of the fixed polynomial predictors.
+func (r *flacBitReader) readFixed(samples []int32, order int, bps uint) error {
+	if err := r.readWarmUp(samples, order, bps); err != nil {
+		return err
+	}
+	if err := r.readResidual(samples, order); err != nil {
+		return err
+	}
+	s := samples
+	switch order {
+	case 1:
+		for i := 1; i < len(s); i++ {
+			s[i] += s[i-1]
+		}
+	case 2:
+		for i := 2; i < len(s); i++ {
+			s[i] += 2*s[i-1] - s[i-2]
+		}
+	case 3:
+		for i := 3; i < len(s); i++ {
+			s[i] += 3*s[i-1] - 3*s[i-2] + s[i-3]
+		}
+	case 4:
+		for i := 4; i < len(s); i++ {
+			s[i] += 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+		}
+	}
+	return nil
+}
+
+// readLPC decodes a subframe using linear prediction.
+func (r *flacBitReader) readLPC(samples []int32, order int, bps uint) error {
+	if err := r.readWarmUp(samples, order, bps); err != nil {
+		return err
+	}
+	precision, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	if precision == 0xF {
+		return errors.New("Invalid FLAC LPC precision")
+	}
+	shift, err := r.readSigned(5)
+	if err != nil {
+		return err
+	}
+	if shift < 0 {
+		return errors.New("Invalid FLAC LPC shift")
+	}
+	coefficients := make([]int64, order)
+	for i := range coefficients {
+		if coefficients[i], err = r.readSigned(uint(precision) + 1); err != nil {
+			return err
+		}
+	}
+	if err := r.readResidual(samples, order); err != nil {
+		return err
+	}
+	for i := order; i < len(samples); i++ {
+		prediction := int64(0)
+		for j, c := range coefficients {
+			prediction += c * int64(samples[i-j-1])
+		}
+		samples[i] += int32(prediction >> uint(shift))
+	}
+	return nil
+}
+
+// readResidual of a predicted subframe into samples, after the warm-up samples.
+func (r *flacBitReader) readResidual(samples []int32, order int) error {
+	method, err := r.readBits(2)
+	if err != nil {
+		return err
+	}
+	paramBits, escape := uint(4), uint64(0xF)
+	switch method {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 0x1F
+	default:
+		return errors.New("Reserved FLAC residual coding method")
+	}
+	partitionOrder, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return errors.New("Invalid FLAC partition order")
+	}
+
+	i := order
+	for p := 0; p < 1<<partitionOrder; p++ {
+		end := (p + 1) * partitionSize
+		param, err := r.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := r.readBits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				v, err := r.readSigned(uint(n))
+				if err != nil {
+					return err
+				}
+				samples[i] = int32(v)
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := r.readUnary()
+			if err != nil {
+				return err
+			}
+			low, err := r.readBits(uint(param))
+			if err != nil {
+				return err
+			}
+			u := q<<param | low
+			samples[i] = int32(u>>1) ^ -int32(u&1)
+		}
+	}
+	return nil
+}
+
+// flacBitReader reads big-endian bit fields, updating the CRCs of the bytes read.
+type flacBitReader struct {
+	r     *bufio.Reader
+	cache uint64 // unread bits are the n lowest
+	n     uint
+	crc8  uint8
+	crc16 uint16
+	read  int64 // bytes read
+}
+
+func (r *flacBitReader) fill() error {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	r.cache = r.cache<<8 | uint64(b)
+	r.n += 8
+	r.read++
+	r.crc8 = flacCRC8Table[r.crc8^b]
+	r.crc16 = r.crc16<<8 ^ flacCRC16Table[byte(r.crc16>>8)^b]
+	return nil
+}
+
+// readBits as an unsigned integer, up to 56 bits.
+func (r *flacBitReader) readBits(n uint) (uint64, error) {
+	for r.n < n {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	r.n -= n
+	return r.cache >> r.n & (1<<n - 1), nil
+}
+
+// readSigned two's complement integer.
+func (r *flacBitReader) readSigned(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary number of zeros before the next 1.
+func (r *flacBitReader) readUnary() (uint64, error) {
+	zeros := uint64(0)
+	for {
+		if r.n == 0 {
+			if err := r.fill(); err != nil {
+				return 0, err
+			}
+		}
+		v := r.cache & (1<<r.n - 1)
+		if v == 0 {
+			zeros += uint64(r.n)
+			r.n = 0
+			continue
+		}
+		leading := uint(bits.LeadingZeros64(v)) - (64 - r.n)
+		r.n -= leading + 1
+		return zeros + uint64(leading), nil
+	}
+}
+
+// readUTF8 coded frame or sample number.
+func (r *flacBitReader) readUTF8() (uint64, error) {
+	first, err := r.readBits(8)
+	if err != nil {
+		return 0, err
+	}
+	length := bits.LeadingZeros8(^uint8(first))
+	switch {
+	case length == 0:
+		return first, nil
+	case length == 1 || length > 7:
+		return 0, errors.New("Invalid FLAC frame number")
+	}
+	v := first & (0x7F >> uint(length))
+	for i := 1; i < length; i++ {
+		b, err := r.readBits(8)
+		if err != nil {
+			return 0, err
+		}
+		if b&0xC0 != 0x80 {
+			return 0, errors.New("Invalid FLAC frame number")
+		}
+		v = v<<6 | b&0x3F
+	}
+	return v, nil
+}
+
+// align to the next byte boundary.
+func (r *flacBitReader) align() {
+	r.n -= r.n % 8
+}
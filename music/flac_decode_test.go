@@ -0,0 +1,416 @@
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBitWriter writes big-endian bit fields, to encode test FLAC frames.
+type testBitWriter struct {
+	data  []byte
+	cache uint64
+	n     uint
+}
+
+func (w *testBitWriter) writeBits(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.cache = w.cache<<1 | v>>uint(i)&1
+		w.n++
+		if w.n == 8 {
+			w.data = append(w.data, byte(w.cache))
+			w.cache, w.n = 0, 0
+		}
+	}
+}
+
+func (w *testBitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v)&(1<<n-1), n)
+}
+
+func (w *testBitWriter) writeRice(v int32, param uint) {
+	u := uint64(v<<1 ^ v>>31)
+	for q := u >> param; q > 0; q-- {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(1, 1)
+	w.writeBits(u, param)
+}
+
+func (w *testBitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		w.writeBits(v, 8)
+		return
+	}
+	length := uint(2)
+	for v>>(5*length+1) != 0 {
+		length++
+	}
+	w.writeBits(0xFF<<(8-length)&0xFF|v>>(6*(length-1)), 8)
+	for i := int(length) - 2; i >= 0; i-- {
+		w.writeBits(0x80|v>>(6*uint(i))&0x3F, 8)
+	}
+}
+
+func (w *testBitWriter) align() {
+	for w.n != 0 {
+		w.writeBits(0, 1)
+	}
+}
+
+// testSubframe encodes a channel of a test frame.
+type testSubframe struct {
+	kind         string // constant, verbatim, fixed or lpc
+	order        int
+	coefficients []int64 // lpc
+	shift        uint    // lpc
+	wasted       uint
+	escape       bool // escaped partitions, with raw residuals
+}
+
+func (s testSubframe) write(w *testBitWriter, samples []int32, bps uint) {
+	w.writeBits(0, 1)
+	switch s.kind {
+	case "constant":
+		w.writeBits(0, 6)
+	case "verbatim":
+		w.writeBits(1, 6)
+	case "fixed":
+		w.writeBits(uint64(8+s.order), 6)
+	case "lpc":
+		w.writeBits(uint64(31+len(s.coefficients)), 6)
+	}
+	if s.wasted != 0 {
+		w.writeBits(1, 1)
+		for i := uint(1); i < s.wasted; i++ {
+			w.writeBits(0, 1)
+		}
+		w.writeBits(1, 1)
+		bps -= s.wasted
+		shifted := make([]int32, len(samples))
+		for i := range samples {
+			shifted[i] = samples[i] >> s.wasted
+		}
+		samples = shifted
+	} else {
+		w.writeBits(0, 1)
+	}
+
+	switch s.kind {
+	case "constant":
+		w.writeSigned(int64(samples[0]), bps)
+		return
+	case "verbatim":
+		for _, v := range samples {
+			w.writeSigned(int64(v), bps)
+		}
+		return
+	}
+
+	order := s.order
+	if s.kind == "lpc" {
+		order = len(s.coefficients)
+	}
+	residual := make([]int32, len(samples))
+	for i := range samples {
+		if i < order {
+			w.writeSigned(int64(samples[i]), bps)
+			continue
+		}
+		prediction := int64(0)
+		if s.kind == "fixed" {
+			fixed := [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}[order]
+			for j, c := range fixed {
+				prediction += c * int64(samples[i-j-1])
+			}
+		} else {
+			for j, c := range s.coefficients {
+				prediction += c * int64(samples[i-j-1])
+			}
+			prediction >>= s.shift
+		}
+		residual[i] = samples[i] - int32(prediction)
+	}
+	if s.kind == "lpc" {
+		w.writeBits(14, 4) // 15 bits of precision
+		w.writeSigned(int64(s.shift), 5)
+		for _, c := range s.coefficients {
+			w.writeSigned(c, 15)
+		}
+	}
+
+	// Rice2 coding, 2 partitions
+	w.writeBits(1, 2)
+	w.writeBits(1, 4)
+	half := len(samples) / 2
+	for p, part := range [][]int32{residual[order:half], residual[half:]} {
+		if s.escape && p == 1 {
+			w.writeBits(0x1F, 5)
+			w.writeBits(20, 5)
+			for _, r := range part {
+				w.writeSigned(int64(r), 20)
+			}
+			continue
+		}
+		param := uint(0)
+		for _, r := range part {
+			for r>>param > 3 || -r>>param > 3 {
+				param++
+			}
+		}
+		w.writeBits(uint64(param), 5)
+		for _, r := range part {
+			w.writeRice(r, param)
+		}
+	}
+}
+
+// testFlacFrame encodes a frame, with the sample rate and size of the stream.
+func testFlacFrame(number int, assignment int, bps uint, channels [][]int32, subframes ...testSubframe) []byte {
+	w := &testBitWriter{}
+	w.writeBits(0xFFF8, 16)
+	w.writeBits(7, 4) // 16 bits block size at the end of the header
+	w.writeBits(0, 4)
+	w.writeBits(uint64(assignment), 4)
+	w.writeBits(0, 4)
+	w.writeUTF8(uint64(number))
+	w.writeBits(uint64(len(channels[0])-1), 16)
+	w.writeBits(uint64(flacCRC8(w.data)), 8)
+
+	coded := make([][]int32, len(channels))
+	copy(coded, channels)
+	switch assignment {
+	case flacLeftSide, flacSideRight, flacMidSide:
+		side := make([]int32, len(channels[0]))
+		for i := range side {
+			side[i] = channels[0][i] - channels[1][i]
+		}
+		switch assignment {
+		case flacLeftSide:
+			coded[1] = side
+		case flacSideRight:
+			coded[0] = side
+		case flacMidSide:
+			mid := make([]int32, len(side))
+			for i := range mid {
+				mid[i] = (channels[0][i] + channels[1][i]) >> 1
+			}
+			coded[0], coded[1] = mid, side
+		}
+	}
+	for c, samples := range coded {
+		channelBps := bps
+		if (assignment == flacSideRight && c == 0) || ((assignment == flacLeftSide || assignment == flacMidSide) && c == 1) {
+			channelBps++
+		}
+		subframes[c].write(w, samples, channelBps)
+	}
+	w.align()
+	w.writeBits(uint64(flacCRC16(w.data)), 16)
+	return w.data
+}
+
+// writeTestAudioFlac encodes 16-bit audio in frames of 4096 samples, with
+// its MD5 in STREAMINFO. It returns the offsets of the frames in the file.
+func writeTestAudioFlac(t *testing.T, path string, sampleRate uint32, channels [][]int32) []int64 {
+	const blockSize = 4096
+	audio, offsets := []byte{}, []int64{}
+	for i := 0; i*blockSize < len(channels[0]); i++ {
+		end := (i + 1) * blockSize
+		if end > len(channels[0]) {
+			end = len(channels[0])
+		}
+		frame, subframes := [][]int32{}, []testSubframe{}
+		for _, c := range channels {
+			frame = append(frame, c[i*blockSize:end])
+			subframes = append(subframes, testSubframe{kind: "fixed", order: 2})
+		}
+		offsets = append(offsets, int64(len(audio)))
+		audio = append(audio, testFlacFrame(i, len(channels)-1, 16, frame, subframes...)...)
+	}
+	data := testFlacBytes([]testFlacBlock{
+		{FlacStreamInfoBlock, testStreamInfo(sampleRate, uint8(len(channels)), 16, uint64(len(channels[0])), md5.Sum(appendPCM(nil, channels, 16)))},
+	}, audio)
+	for i := range offsets {
+		offsets[i] += int64(len(data) - len(audio))
+	}
+	require.Nil(t, ioutil.WriteFile(path, data, 0644))
+	return offsets
+}
+
+// testSine samples, with some deterministic noise.
+func testSine(length int, frequency, sampleRate float64, amplitude float64, seed int) []int32 {
+	samples := make([]int32, length)
+	noise := uint32(seed)
+	for i := range samples {
+		noise = noise*1664525 + 1013904223
+		samples[i] = int32(amplitude*math.Sin(2*math.Pi*frequency*float64(i)/sampleRate)) + int32(noise>>28) - 8
+	}
+	return samples
+}
+
+func TestFlacDecoder(t *testing.T) {
+	fmt.Println("+ Testing FLAC decoding...")
+	check := assert.New(t)
+
+	const blockSize = 1024
+	left := testSine(4*blockSize+100, 440, 44100, 20000, 1)
+	right := testSine(4*blockSize+100, 660, 44100, 12000, 2)
+	for i := 3 * blockSize; i < 4*blockSize; i++ {
+		// last full frame has 3 wasted bits
+		left[i], right[i] = left[i]&^7, right[i]&^7
+	}
+	frame := func(i, length int) [][]int32 {
+		return [][]int32{left[i*blockSize : i*blockSize+length], right[i*blockSize : i*blockSize+length]}
+	}
+	constant := make([]int32, blockSize)
+	for i := range constant {
+		constant[i] = -1234
+	}
+
+	audio := []byte{}
+	audio = append(audio, testFlacFrame(0, 1, 16, frame(0, blockSize),
+		testSubframe{kind: "verbatim"}, testSubframe{kind: "fixed", order: 2})...)
+	audio = append(audio, testFlacFrame(1, flacLeftSide, 16, frame(1, blockSize),
+		testSubframe{kind: "fixed", order: 4}, testSubframe{kind: "fixed", order: 1, escape: true})...)
+	audio = append(audio, testFlacFrame(2, flacMidSide, 16, frame(2, blockSize),
+		testSubframe{kind: "lpc", coefficients: []int64{3641, -2048}, shift: 11}, testSubframe{kind: "fixed", order: 3})...)
+	audio = append(audio, testFlacFrame(3, flacSideRight, 16, frame(3, blockSize),
+		testSubframe{kind: "fixed", order: 0, wasted: 3}, testSubframe{kind: "lpc", coefficients: []int64{7282, -4096}, shift: 12, wasted: 3})...)
+	audio = append(audio, testFlacFrame(4, 1, 16, [][]int32{left[4*blockSize:], constant[:100]},
+		testSubframe{kind: "verbatim"}, testSubframe{kind: "constant"})...)
+
+	data := testFlacBytes([]testFlacBlock{
+		{FlacStreamInfoBlock, testStreamInfo(44100, 2, 16, uint64(len(left)), testFlacMD5)},
+	}, audio)
+	d, err := NewFlacDecoder(bytes.NewReader(data))
+	require.Nil(t, err)
+	check.Equal(uint32(44100), d.Info.SampleRate)
+
+	decoded := [][]int32{{}, {}}
+	for n := 0; ; n++ {
+		f, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err, "frame %d", n)
+		check.Equal(uint64(n), f.Number)
+		check.Equal(uint32(44100), f.SampleRate)
+		check.Equal(uint8(16), f.BitsPerSample)
+		require.Equal(t, 2, len(f.Samples))
+		decoded[0] = append(decoded[0], f.Samples[0]...)
+		decoded[1] = append(decoded[1], f.Samples[1]...)
+	}
+	check.Equal(left, decoded[0])
+	check.Equal(append(right[:4*blockSize], constant[:100]...), decoded[1])
+	check.Nil(d.Close())
+
+	// as a PCM stream
+	d, err = NewFlacDecoder(bytes.NewReader(data))
+	require.Nil(t, err)
+	pcm, err := ioutil.ReadAll(d)
+	require.Nil(t, err)
+	require.Equal(t, len(left)*2*2, len(pcm))
+	for i := range left {
+		check.Equal(int16(decoded[0][i]), int16(binary.LittleEndian.Uint16(pcm[4*i:])))
+		check.Equal(int16(decoded[1][i]), int16(binary.LittleEndian.Uint16(pcm[4*i+2:])))
+	}
+
+	// corrupted audio and header
+	for offset, expected := range map[int]error{len(data) - len(audio) + 5: ErrFlacHeaderCRC, len(data) - 100: ErrFlacFrameCRC} {
+		corrupted := append([]byte{}, data...)
+		corrupted[offset] ^= 0x01
+		d, err = NewFlacDecoder(bytes.NewReader(corrupted))
+		require.Nil(t, err)
+		_, err = ioutil.ReadAll(d)
+		check.Equal(expected, err)
+	}
+
+	// truncated stream, within a frame, at byte boundaries or not
+	for _, size := range []int{len(data) - len(audio)/2, len(data) - len(audio) + 1, len(data) - len(audio) + 2, len(data) - 2, len(data) - 1} {
+		d, err = NewFlacDecoder(bytes.NewReader(data[:size]))
+		require.Nil(t, err)
+		frames := []*FlacFrame{}
+		var f *FlacFrame
+		for err == nil {
+			if f, err = d.ReadFrame(); err == nil {
+				frames = append(frames, f)
+			}
+		}
+		check.True(errors.Is(err, io.ErrUnexpectedEOF), "truncated at %d: %v", size, err)
+		check.True(d.Offset() < int64(size))
+		if len(frames) != 0 {
+			check.True(d.Offset() > frames[len(frames)-1].Offset)
+		}
+	}
+	// truncated between frames
+	d, err = NewFlacDecoder(bytes.NewReader(data[:len(data)-len(audio)]))
+	require.Nil(t, err)
+	_, err = d.ReadFrame()
+	check.Equal(io.EOF, err)
+
+	// garbage instead of a frame
+	d, err = NewFlacDecoder(bytes.NewReader(append(data[:len(data)-len(audio)], 0, 1, 2, 3)))
+	require.Nil(t, err)
+	_, err = d.ReadFrame()
+	check.NotNil(err)
+}
+
+func TestFlacCRC(t *testing.T) {
+	fmt.Println("+ Testing FLAC CRCs...")
+	check := assert.New(t)
+
+	check.Equal(uint8(0xF4), flacCRC8([]byte("123456789")))
+	check.Equal(uint16(0xFEE8), flacCRC16([]byte("123456789")))
+	check.Equal(uint8(0), flacCRC8(nil))
+}
+
+func TestFlacBitReader(t *testing.T) {
+	fmt.Println("+ Testing FLAC bit reader...")
+	check := assert.New(t)
+
+	w := &testBitWriter{}
+	w.writeBits(5, 3)
+	w.writeSigned(-3, 4)
+	w.writeBits(0, 20)
+	w.writeBits(1, 1)
+	w.writeRice(-7, 2)
+	w.writeUTF8(0x123)
+	w.writeUTF8(0x12345)
+	w.align()
+
+	r := &flacBitReader{r: bufio.NewReader(bytes.NewReader(w.data))}
+	v, err := r.readBits(3)
+	check.Nil(err)
+	check.Equal(uint64(5), v)
+	s, err := r.readSigned(4)
+	check.Nil(err)
+	check.Equal(int64(-3), s)
+	zeros, err := r.readUnary()
+	check.Nil(err)
+	check.Equal(uint64(20), zeros)
+	q, err := r.readUnary()
+	check.Nil(err)
+	low, err := r.readBits(2)
+	check.Nil(err)
+	check.Equal(uint64(13), q<<2|low, "-7 zigzag encoded")
+	n, err := r.readUTF8()
+	check.Nil(err)
+	check.Equal(uint64(0x123), n)
+	n, err = r.readUTF8()
+	check.Nil(err)
+	check.Equal(uint64(0x12345), n)
+	r.align()
+	_, err = r.readBits(1)
+	check.Equal(io.EOF, err)
+}
@@ -0,0 +1,200 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	// registering decoders for image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// FLAC picture types, from the ID3v2 APIC frame.
+const (
+	PictureOther              uint32 = 0
+	PictureFileIcon           uint32 = 1
+	PictureOtherFileIcon      uint32 = 2
+	PictureFrontCover         uint32 = 3
+	PictureBackCover          uint32 = 4
+	PictureLeaflet            uint32 = 5
+	PictureMedia              uint32 = 6
+	PictureLeadArtist         uint32 = 7
+	PictureArtist             uint32 = 8
+	PictureConductor          uint32 = 9
+	PictureBand               uint32 = 10
+	PictureComposer           uint32 = 11
+	PictureLyricist           uint32 = 12
+	PictureRecordingLocation  uint32 = 13
+	PictureDuringRecording    uint32 = 14
+	PictureDuringPerformance  uint32 = 15
+	PictureVideoCapture       uint32 = 16
+	PictureBrightColouredFish uint32 = 17
+	PictureIllustration       uint32 = 18
+	PictureBandLogo           uint32 = 19
+	PicturePublisherLogo      uint32 = 20
+)
+
+// coverArtFilenames are the files written next to the album by ExtractCover.
+var coverArtFilenames = []string{"cover", "folder"}
+
+// NewFlacPicture from image data, detecting its MIME type, dimensions and color depth.
+func NewFlacPicture(pictureType uint32, data []byte, description string) (*FlacPicture, error) {
+	if pictureType > PicturePublisherLogo {
+		return nil, errors.New("Invalid picture type")
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("Could not read image: " + err.Error())
+	}
+	p := &FlacPicture{
+		Type:        pictureType,
+		MIME:        http.DetectContentType(data),
+		Description: description,
+		Width:       uint32(config.Width),
+		Height:      uint32(config.Height),
+		Data:        data,
+	}
+	// only 32x32 PNG file icons are allowed
+	if pictureType == PictureFileIcon && (format != "png" || config.Width != 32 || config.Height != 32) {
+		return nil, errors.New("File icons must be 32x32 PNG images")
+	}
+	switch model := config.ColorModel.(type) {
+	case color.Palette:
+		p.Depth = 8
+		p.Colors = uint32(len(model))
+	default:
+		p.Depth = colorDepth(model)
+	}
+	return p, nil
+}
+
+// NewFlacPictureFromFile reads an image file to embed.
+func NewFlacPictureFromFile(pictureType uint32, path string) (*FlacPicture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFlacPicture(pictureType, data, "")
+}
+
+func colorDepth(model color.Model) uint32 {
+	switch model {
+	case color.GrayModel:
+		return 8
+	case color.Gray16Model:
+		return 16
+	case color.YCbCrModel:
+		return 24
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 64
+	}
+	// RGBA, NRGBA, CMYK
+	return 32
+}
+
+// Extension for the picture file, from its MIME type.
+func (p *FlacPicture) Extension() string {
+	switch p.MIME {
+	case "image/jpeg", "image/jpg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ".img"
+}
+
+func encodePicture(p FlacPicture) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.BigEndian, p.Type)
+	binary.Write(b, binary.BigEndian, uint32(len(p.MIME)))
+	b.WriteString(p.MIME)
+	binary.Write(b, binary.BigEndian, uint32(len(p.Description)))
+	b.WriteString(p.Description)
+	binary.Write(b, binary.BigEndian, []uint32{p.Width, p.Height, p.Depth, p.Colors, uint32(len(p.Data))})
+	b.Write(p.Data)
+	return b.Bytes()
+}
+
+// Picture of a given type, or nil.
+func (f *FlacFile) Picture(pictureType uint32) *FlacPicture {
+	for i := range f.Pictures {
+		if f.Pictures[i].Type == pictureType {
+			return &f.Pictures[i]
+		}
+	}
+	return nil
+}
+
+// FrontCover of the file, falling back to the first picture if none is marked as front cover.
+func (f *FlacFile) FrontCover() *FlacPicture {
+	if p := f.Picture(PictureFrontCover); p != nil {
+		return p
+	}
+	if len(f.Pictures) != 0 {
+		return &f.Pictures[0]
+	}
+	return nil
+}
+
+// AddPicture to the file, keeping existing pictures of the same type.
+func (f *FlacFile) AddPicture(p *FlacPicture) error {
+	if (p.Type == PictureFileIcon || p.Type == PictureOtherFileIcon) && f.Picture(p.Type) != nil {
+		return errors.New("Only one file icon of each type is allowed")
+	}
+	f.Pictures = append(f.Pictures, *p)
+	return nil
+}
+
+// SetPicture replaces all pictures of the same type.
+func (f *FlacFile) SetPicture(p *FlacPicture) {
+	f.RemovePictures(p.Type)
+	f.Pictures = append(f.Pictures, *p)
+}
+
+// RemovePictures of a given type.
+func (f *FlacFile) RemovePictures(pictureType uint32) {
+	pictures := []FlacPicture{}
+	for _, p := range f.Pictures {
+		if p.Type != pictureType {
+			pictures = append(pictures, p)
+		}
+	}
+	f.Pictures = pictures
+}
+
+// RemoveAllPictures from the file.
+func (f *FlacFile) RemoveAllPictures() {
+	f.Pictures = []FlacPicture{}
+}
+
+// ExtractCover writes the front cover as cover.jpg and folder.jpg (or .png)
+// in the directory of the file, and returns the paths that were written.
+// Existing files are only replaced if overwrite is set.
+func (f *FlacFile) ExtractCover(overwrite bool) ([]string, error) {
+	p := f.FrontCover()
+	if p == nil {
+		return nil, errors.New("No embedded picture")
+	}
+	written := []string{}
+	for _, name := range coverArtFilenames {
+		path := filepath.Join(filepath.Dir(f.Path), name+p.Extension())
+		if _, err := os.Stat(path); err == nil && !overwrite {
+			continue
+		}
+		if err := ioutil.WriteFile(path, p.Data, 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,120 @@
+package music
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(t *testing.T, format string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	b := new(bytes.Buffer)
+	var err error
+	if format == "png" {
+		err = png.Encode(b, img)
+	} else {
+		err = jpeg.Encode(b, img, nil)
+	}
+	require.Nil(t, err)
+	return b.Bytes()
+}
+
+func TestFlacPictures(t *testing.T) {
+	fmt.Println("+ Testing FLAC pictures...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := writeTestFlac(t, dir, 1024)
+	originalAudio := audioMD5(t, path)
+
+	// image detection
+	front, err := NewFlacPicture(PictureFrontCover, testImage(t, "jpeg", 60, 50), "Front")
+	require.Nil(t, err)
+	check.Equal("image/jpeg", front.MIME)
+	check.Equal(uint32(60), front.Width)
+	check.Equal(uint32(50), front.Height)
+	check.Equal(uint32(24), front.Depth)
+	check.Equal(".jpg", front.Extension())
+
+	back, err := NewFlacPicture(PictureBackCover, testImage(t, "png", 40, 30), "")
+	require.Nil(t, err)
+	check.Equal("image/png", back.MIME)
+	check.Equal(uint32(40), back.Width)
+	check.Equal(".png", back.Extension())
+
+	_, err = NewFlacPicture(PictureFrontCover, []byte("not an image"), "")
+	check.NotNil(err)
+	_, err = NewFlacPicture(PictureFileIcon, testImage(t, "png", 40, 30), "")
+	check.NotNil(err, "File icons must be 32x32")
+	_, err = NewFlacPicture(21, testImage(t, "png", 40, 30), "")
+	check.NotNil(err)
+
+	// replace the existing front cover, add a back cover
+	f, err := ReadFlac(path)
+	require.Nil(t, err)
+	require.NotNil(t, f.Picture(PictureFrontCover))
+	f.SetPicture(front)
+	check.Nil(f.AddPicture(back))
+	check.Equal(2, len(f.Pictures))
+	require.Nil(t, f.Save())
+
+	f, err = ReadFlac(path)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(f.Pictures))
+	p := f.Picture(PictureFrontCover)
+	require.NotNil(t, p)
+	check.Equal("Front", p.Description)
+	check.Equal(front.Data, p.Data)
+	check.Equal(uint32(24), p.Depth)
+	p = f.Picture(PictureBackCover)
+	require.NotNil(t, p)
+	check.Equal(back.Data, p.Data)
+	check.Nil(f.Picture(PictureMedia))
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+	check.Equal(testFlacMD5, f.StreamInfo.MD5, "STREAMINFO MD5 was modified")
+
+	// extraction next to the file
+	written, err := f.ExtractCover(false)
+	require.Nil(t, err)
+	check.Equal([]string{filepath.Join(dir, "cover.jpg"), filepath.Join(dir, "folder.jpg")}, written)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cover.jpg"))
+	require.Nil(t, err)
+	check.Equal(front.Data, data)
+	written, err = f.ExtractCover(false)
+	require.Nil(t, err)
+	check.Equal(0, len(written), "Existing files should not be overwritten")
+	written, err = f.ExtractCover(true)
+	require.Nil(t, err)
+	check.Equal(2, len(written))
+
+	// removal
+	f.RemovePictures(PictureBackCover)
+	require.Nil(t, f.Save())
+	check.Equal(1, len(f.Pictures))
+	check.Nil(f.Picture(PictureBackCover))
+	f.RemoveAllPictures()
+	require.Nil(t, f.Save())
+	check.Equal(0, len(f.Pictures))
+	check.Nil(f.FrontCover())
+	_, err = f.ExtractCover(true)
+	check.NotNil(err)
+	check.Equal("Radiohead", f.Comments.GetFirst("ARTIST"))
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+}
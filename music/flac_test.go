@@ -0,0 +1,245 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFlacMD5 = [16]byte{0xde, 0xad, 0xbe, 0xef, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+type testFlacBlock struct {
+	blockType FlacBlockType
+	data      []byte
+}
+
+func testStreamInfo(sampleRate uint32, channels, bps uint8, totalSamples uint64, md5 [16]byte) []byte {
+	data := make([]byte, 34)
+	binary.BigEndian.PutUint16(data[0:2], 4096)
+	binary.BigEndian.PutUint16(data[2:4], 4096)
+	packed := uint64(sampleRate)<<44 | uint64(channels-1)<<41 | uint64(bps-1)<<36 | totalSamples
+	binary.BigEndian.PutUint64(data[10:18], packed)
+	copy(data[18:], md5[:])
+	return data
+}
+
+func testVorbisComment(vendor string, comments ...string) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.LittleEndian, uint32(len(vendor)))
+	b.WriteString(vendor)
+	binary.Write(b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+func testPicture(pictureType uint32, mime, description string, data []byte) []byte {
+	b := new(bytes.Buffer)
+	binary.Write(b, binary.BigEndian, pictureType)
+	binary.Write(b, binary.BigEndian, uint32(len(mime)))
+	b.WriteString(mime)
+	binary.Write(b, binary.BigEndian, uint32(len(description)))
+	b.WriteString(description)
+	binary.Write(b, binary.BigEndian, []uint32{500, 400, 24, 0, uint32(len(data))})
+	b.Write(data)
+	return b.Bytes()
+}
+
+func testCueSheet() []byte {
+	b := new(bytes.Buffer)
+	catalog := make([]byte, 128)
+	copy(catalog, "1234567890123")
+	b.Write(catalog)
+	binary.Write(b, binary.BigEndian, uint64(88200))
+	b.WriteByte(0x80)
+	b.Write(make([]byte, 258))
+	b.WriteByte(2)
+	// track 1, two indexes
+	binary.Write(b, binary.BigEndian, uint64(0))
+	b.WriteByte(1)
+	b.WriteString("USRC17607839")
+	b.WriteByte(0)
+	b.Write(make([]byte, 13))
+	b.WriteByte(2)
+	for i, offset := range []uint64{0, 588} {
+		binary.Write(b, binary.BigEndian, offset)
+		b.WriteByte(byte(i))
+		b.Write(make([]byte, 3))
+	}
+	// lead-out
+	binary.Write(b, binary.BigEndian, uint64(441000))
+	b.WriteByte(170)
+	b.Write(make([]byte, 12))
+	b.WriteByte(0)
+	b.Write(make([]byte, 13))
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// testFlacBytes assembles a FLAC file from metadata blocks and audio data.
+func testFlacBytes(blocks []testFlacBlock, audio []byte) []byte {
+	b := new(bytes.Buffer)
+	b.WriteString(flacMagic)
+	for i, block := range blocks {
+		header := byte(block.blockType)
+		if i == len(blocks)-1 {
+			header |= 0x80
+		}
+		b.Write([]byte{header, byte(len(block.data) >> 16), byte(len(block.data) >> 8), byte(len(block.data))})
+		b.Write(block.data)
+	}
+	b.Write(audio)
+	return b.Bytes()
+}
+
+// testFlacAudio returns fake audio frames, only the metadata is ever parsed.
+func testFlacAudio() []byte {
+	audio := make([]byte, 8192)
+	for i := range audio {
+		audio[i] = byte(i * 7)
+	}
+	audio[0], audio[1] = 0xFF, 0xF8
+	return audio
+}
+
+// writeTestFlac with every kind of metadata block, returning its path.
+func writeTestFlac(t *testing.T, dir string, paddingSize int) string {
+	blocks := []testFlacBlock{
+		{FlacStreamInfoBlock, testStreamInfo(44100, 2, 16, 441000, testFlacMD5)},
+		{FlacVorbisCommentBlock, testVorbisComment("reference libFLAC 1.3.2 20170101",
+			"ARTIST=Radiohead", "ALBUM=Kid A", "TITLE=Everything in Its Right Place",
+			"tracknumber=1", "GENRE=Rock", "GENRE=Electronic")},
+		{FlacPictureBlock, testPicture(3, "image/jpeg", "front", []byte("not really a jpeg"))},
+		{FlacSeekTableBlock, append(
+			[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10, 0},
+			0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)},
+		{FlacApplicationBlock, []byte("ATCHsome attachment")},
+		{FlacCueSheetBlock, testCueSheet()},
+	}
+	if paddingSize != 0 {
+		blocks = append(blocks, testFlacBlock{FlacPaddingBlock, make([]byte, paddingSize)})
+	}
+	path := filepath.Join(dir, "test.flac")
+	if err := ioutil.WriteFile(path, testFlacBytes(blocks, testFlacAudio()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFlacRead(t *testing.T) {
+	fmt.Println("+ Testing FLAC metadata...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := writeTestFlac(t, dir, 1024)
+
+	f, err := ReadFlac(path)
+	require.Nil(t, err, "Unexpected error reading FLAC file")
+	check.Equal(path, f.Path)
+
+	// STREAMINFO
+	check.Equal(uint32(44100), f.StreamInfo.SampleRate)
+	check.Equal(uint8(2), f.StreamInfo.Channels)
+	check.Equal(uint8(16), f.StreamInfo.BitsPerSample)
+	check.Equal(uint64(441000), f.StreamInfo.TotalSamples)
+	check.Equal(uint16(4096), f.StreamInfo.MaxBlockSize)
+	check.Equal(testFlacMD5, f.StreamInfo.MD5)
+	check.Equal(10*time.Second, f.StreamInfo.Duration())
+
+	// VORBIS_COMMENT
+	require.NotNil(t, f.Comments)
+	check.Equal("reference libFLAC 1.3.2 20170101", f.Comments.Vendor)
+	check.Equal(6, len(f.Comments.Tags))
+	check.Equal("Radiohead", f.Comments.GetFirst("artist"))
+	check.Equal("1", f.Comments.GetFirst("TRACKNUMBER"))
+	check.Equal([]string{"Rock", "Electronic"}, f.Comments.Get("genre"))
+	check.Equal("", f.Comments.GetFirst("DATE"))
+
+	// PICTURE
+	require.Equal(t, 1, len(f.Pictures))
+	check.Equal(uint32(3), f.Pictures[0].Type)
+	check.Equal("image/jpeg", f.Pictures[0].MIME)
+	check.Equal("front", f.Pictures[0].Description)
+	check.Equal(uint32(500), f.Pictures[0].Width)
+	check.Equal(uint32(400), f.Pictures[0].Height)
+	check.Equal(uint32(24), f.Pictures[0].Depth)
+	check.Equal([]byte("not really a jpeg"), f.Pictures[0].Data)
+
+	// SEEKTABLE
+	require.Equal(t, 2, len(f.SeekTable))
+	check.False(f.SeekTable[0].IsPlaceholder())
+	check.Equal(uint16(4096), f.SeekTable[0].Samples)
+	check.True(f.SeekTable[1].IsPlaceholder())
+
+	// APPLICATION
+	require.Equal(t, 1, len(f.Applications))
+	check.Equal("ATCH", f.Applications[0].ID)
+	check.Equal([]byte("some attachment"), f.Applications[0].Data)
+
+	// CUESHEET
+	require.NotNil(t, f.CueSheet)
+	check.Equal("1234567890123", f.CueSheet.MediaCatalogNumber)
+	check.Equal(uint64(88200), f.CueSheet.LeadInSamples)
+	check.True(f.CueSheet.IsCD)
+	require.Equal(t, 2, len(f.CueSheet.Tracks))
+	check.Equal("USRC17607839", f.CueSheet.Tracks[0].ISRC)
+	check.True(f.CueSheet.Tracks[0].IsAudio)
+	check.Equal(2, len(f.CueSheet.Tracks[0].Indexes))
+	check.Equal(uint64(588), f.CueSheet.Tracks[0].Indexes[1].Offset)
+	check.Equal(uint8(170), f.CueSheet.Tracks[1].Number)
+
+	// PADDING & layout
+	check.Equal(1024, f.Padding)
+	check.Equal(7, len(f.Blocks))
+	check.Equal(FlacPaddingBlock, f.Blocks[6].Type)
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	check.Equal(info.Size()-int64(len(testFlacAudio())), f.AudioOffset)
+}
+
+func TestFlacReadErrors(t *testing.T) {
+	fmt.Println("+ Testing FLAC metadata errors...")
+	check := assert.New(t)
+
+	_, err := ParseFlac(bytes.NewReader([]byte("ID3 not a flac file")))
+	check.NotNil(err)
+
+	// first block must be STREAMINFO
+	data := testFlacBytes([]testFlacBlock{{FlacPaddingBlock, make([]byte, 10)}}, testFlacAudio())
+	_, err = ParseFlac(bytes.NewReader(data))
+	check.NotNil(err)
+
+	// truncated block
+	data = testFlacBytes([]testFlacBlock{
+		{FlacStreamInfoBlock, testStreamInfo(44100, 2, 16, 0, testFlacMD5)},
+		{FlacPaddingBlock, make([]byte, 100)},
+	}, nil)
+	_, err = ParseFlac(bytes.NewReader(data[:len(data)-10]))
+	check.NotNil(err)
+
+	// truncated PICTURE, anywhere in its fields
+	picture := testPicture(3, "image/jpeg", "front", nil)
+	for _, length := range []int{2, 30, len(picture) - 2} {
+		_, err = parsePicture(picture[:length])
+		check.NotNil(err, "Truncated at %d", length)
+	}
+	p, err := parsePicture(picture)
+	require.Nil(t, err)
+	check.Equal(0, len(p.Data))
+
+	// the MP3 test track is not a FLAC file
+	_, err = ReadFlac(testTracks[0].path)
+	check.NotNil(err)
+}
@@ -0,0 +1,120 @@
+package music
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// FLAC verification errors.
+var (
+	ErrFlacMD5Mismatch    = errors.New("Decoded audio does not match the STREAMINFO MD5")
+	ErrFlacSampleMismatch = errors.New("Decoded audio does not have the STREAMINFO number of samples")
+)
+
+// FlacVerification is the result of the verification of a FLAC file.
+type FlacVerification struct {
+	Path    string
+	Err     error // first problem found, nil if the file is intact
+	Offset  int64 // of the first corrupt or missing frame, -1 otherwise
+	Frames  int
+	Samples uint64
+	MD5     [16]byte // of the decoded audio
+	// Signed if STREAMINFO has an MD5 to check the audio against.
+	Signed bool
+}
+
+// OK if no problem was found.
+func (v FlacVerification) OK() bool {
+	return v.Err == nil
+}
+
+// VerifyFlac decodes every frame of a FLAC file, checking their CRCs, and
+// compares the MD5 of the decoded audio with the one in STREAMINFO.
+func VerifyFlac(path string) FlacVerification {
+	v := FlacVerification{Path: path, Offset: -1}
+	d, err := OpenFlacDecoder(path)
+	if err != nil {
+		v.Err = err
+		return v
+	}
+	defer d.Close()
+	v.Signed = d.Info.MD5 != [16]byte{}
+
+	hash := md5.New()
+	pcm := []byte{}
+	for {
+		frame, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			v.Err, v.Offset = err, d.Offset()
+			return v
+		}
+		v.Frames++
+		v.Samples += uint64(len(frame.Samples[0]))
+		pcm = appendPCM(pcm[:0], frame.Samples, d.Info.BitsPerSample)
+		hash.Write(pcm)
+	}
+	copy(v.MD5[:], hash.Sum(nil))
+
+	switch {
+	case d.Info.TotalSamples != 0 && v.Samples != d.Info.TotalSamples:
+		// the file ends where the next frame should be
+		v.Err, v.Offset = ErrFlacSampleMismatch, d.Offset()
+	case v.Signed && !bytes.Equal(v.MD5[:], d.Info.MD5[:]):
+		v.Err = ErrFlacMD5Mismatch
+	}
+	return v
+}
+
+// VerifyFlacFiles in parallel, with a number of workers (one per CPU if 0).
+// Results are in the same order as the paths.
+func VerifyFlacFiles(paths []string, workers int) []FlacVerification {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	results := make([]FlacVerification, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = VerifyFlac(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// VerifyFlacLibrary verifies all FLAC files found under root.
+func VerifyFlacLibrary(root string, workers int) ([]FlacVerification, error) {
+	paths := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == flacExtension {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return VerifyFlacFiles(paths, workers), nil
+}
@@ -0,0 +1,121 @@
+package music
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyFlac(t *testing.T) {
+	fmt.Println("+ Testing FLAC verification...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "album"), 0755))
+
+	left, right := testSine(20000, 440, 44100, 10000, 1), testSine(20000, 220, 44100, 10000, 2)
+	intact := filepath.Join(dir, "album", "01.flac")
+	offsets := writeTestAudioFlac(t, intact, 44100, [][]int32{left, right})
+	require.Equal(t, 5, len(offsets))
+
+	v := VerifyFlac(intact)
+	check.True(v.OK(), "%v", v.Err)
+	check.True(v.Signed)
+	check.Equal(int64(-1), v.Offset)
+	check.Equal(5, v.Frames)
+	check.Equal(uint64(20000), v.Samples)
+
+	// corrupt the third frame
+	data, err := ioutil.ReadFile(intact)
+	require.Nil(t, err)
+	data[offsets[2]+100] ^= 0x10
+	corrupt := filepath.Join(dir, "album", "02.flac")
+	require.Nil(t, ioutil.WriteFile(corrupt, data, 0644))
+	v = VerifyFlac(corrupt)
+	check.False(v.OK())
+	check.Equal(ErrFlacFrameCRC, v.Err)
+	check.Equal(offsets[2], v.Offset)
+
+	// intact frames, but not the audio that was encoded
+	left[10] ^= 1
+	modified := filepath.Join(dir, "03.flac")
+	writeTestAudioFlac(t, modified, 44100, [][]int32{left, right})
+	data, err = ioutil.ReadFile(modified)
+	require.Nil(t, err)
+	original, err := ioutil.ReadFile(intact)
+	require.Nil(t, err)
+	copy(data[:offsets[0]], original[:offsets[0]])
+	require.Nil(t, ioutil.WriteFile(modified, data, 0644))
+	v = VerifyFlac(modified)
+	check.Equal(ErrFlacMD5Mismatch, v.Err)
+	check.Equal(int64(-1), v.Offset)
+
+	// truncated within the fourth frame, on a byte boundary, before its CRC
+	truncated := filepath.Join(dir, "04.flac")
+	require.Nil(t, ioutil.WriteFile(truncated, original[:offsets[4]-2], 0644))
+	v = VerifyFlac(truncated)
+	check.True(errors.Is(v.Err, io.ErrUnexpectedEOF), "%v", v.Err)
+	check.Equal(offsets[3], v.Offset)
+	check.Equal(3, v.Frames)
+	// even without STREAMINFO number of samples or MD5 to check
+	unsigned := append([]byte{}, original[:offsets[4]-2]...)
+	unsigned[8+13] &= 0xF0
+	for i := 8 + 14; i < 8+34; i++ {
+		unsigned[i] = 0
+	}
+	require.Nil(t, ioutil.WriteFile(truncated, unsigned, 0644))
+	v = VerifyFlac(truncated)
+	check.False(v.Signed)
+	check.True(errors.Is(v.Err, io.ErrUnexpectedEOF), "%v", v.Err)
+	check.Equal(offsets[3], v.Offset)
+	// truncated between frames
+	require.Nil(t, ioutil.WriteFile(truncated, original[:offsets[4]], 0644))
+	v = VerifyFlac(truncated)
+	check.Equal(ErrFlacSampleMismatch, v.Err)
+	check.Equal(offsets[4], v.Offset)
+	check.Equal(4, v.Frames)
+	require.Nil(t, os.Remove(truncated))
+
+	results, err := VerifyFlacLibrary(dir, 2)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(results))
+	check.Equal(modified, results[0].Path)
+	check.Equal(intact, results[1].Path)
+	check.True(results[1].OK())
+	check.Equal(offsets[2], results[2].Offset)
+
+	// 8-bit samples are hashed signed, unlike in WAV files
+	eight := testSine(5000, 440, 8000, 100, 3)
+	signed, unsigned := []byte{}, []byte{}
+	for _, s := range eight {
+		signed, unsigned = append(signed, byte(int8(s))), append(unsigned, byte(s+128))
+	}
+	audio := testFlacFrame(0, 0, 8, [][]int32{eight}, testSubframe{kind: "fixed", order: 2})
+	for _, sum := range []struct {
+		md5 [16]byte
+		err error
+	}{{md5.Sum(signed), nil}, {md5.Sum(unsigned), ErrFlacMD5Mismatch}} {
+		path := filepath.Join(dir, "8bit.flac")
+		require.Nil(t, ioutil.WriteFile(path, testFlacBytes([]testFlacBlock{
+			{FlacStreamInfoBlock, testStreamInfo(8000, 1, 8, uint64(len(eight)), sum.md5)},
+		}, audio), 0644))
+		v = VerifyFlac(path)
+		check.Equal(sum.err, v.Err)
+		check.Equal(uint64(len(eight)), v.Samples)
+		require.Nil(t, os.Remove(path))
+	}
+
+	v = VerifyFlacFiles([]string{filepath.Join(dir, "missing.flac")}, 0)[0]
+	check.NotNil(v.Err)
+	check.Equal(int64(-1), v.Offset)
+}
@@ -0,0 +1,291 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	flacMaxBlockSize = 1<<24 - 1
+	// flacDefaultPadding is added when the file has to be rewritten, so that
+	// the next tag update can be done in place.
+	flacDefaultPadding = 8192
+	flacVendor         = "aubergine"
+)
+
+// Set a field, replacing all its current values.
+func (vc *FlacVorbisComment) Set(name string, values ...string) {
+	vc.Delete(name)
+	vc.Add(name, values...)
+}
+
+// Add values to a field, keeping the existing ones.
+func (vc *FlacVorbisComment) Add(name string, values ...string) {
+	name = strings.ToUpper(name)
+	for _, v := range values {
+		vc.Tags = append(vc.Tags, FlacTag{Name: name, Value: v})
+	}
+}
+
+// Delete all values of a field.
+func (vc *FlacVorbisComment) Delete(name string) {
+	tags := []FlacTag{}
+	for _, t := range vc.Tags {
+		if !strings.EqualFold(t.Name, name) {
+			tags = append(tags, t)
+		}
+	}
+	vc.Tags = tags
+}
+
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 || c > 0x7D || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeVorbisComment(vc *FlacVorbisComment) ([]byte, error) {
+	b := new(bytes.Buffer)
+	writeString := func(s string) {
+		binary.Write(b, binary.LittleEndian, uint32(len(s)))
+		b.WriteString(s)
+	}
+	writeString(vc.Vendor)
+	binary.Write(b, binary.LittleEndian, uint32(len(vc.Tags)))
+	for _, t := range vc.Tags {
+		if !validFieldName(t.Name) {
+			return nil, fmt.Errorf("Invalid Vorbis comment field name: %q", t.Name)
+		}
+		writeString(t.Name + "=" + t.Value)
+	}
+	return b.Bytes(), nil
+}
+
+// encodeBlocks returns the metadata blocks as they will be written,
+// without any padding.
+func (f *FlacFile) encodeBlocks() ([]FlacMetadataBlock, error) {
+	blocks := []FlacMetadataBlock{}
+	pictures := []FlacMetadataBlock{}
+	for _, p := range f.Pictures {
+		pictures = append(pictures, FlacMetadataBlock{Type: FlacPictureBlock, Data: encodePicture(p)})
+	}
+	commentsWritten, picturesWritten := false, false
+	for _, b := range f.Blocks {
+		switch b.Type {
+		case FlacPaddingBlock:
+			continue
+		case FlacPictureBlock:
+			// pictures replace the original ones, where the first one was
+			if !picturesWritten {
+				blocks = append(blocks, pictures...)
+				picturesWritten = true
+			}
+			continue
+		case FlacVorbisCommentBlock:
+			if commentsWritten || f.Comments == nil {
+				continue
+			}
+			data, err := encodeVorbisComment(f.Comments)
+			if err != nil {
+				return nil, err
+			}
+			b.Data = data
+			commentsWritten = true
+		}
+		blocks = append(blocks, b)
+	}
+	// new comments go right after STREAMINFO
+	if !commentsWritten && f.Comments != nil {
+		data, err := encodeVorbisComment(f.Comments)
+		if err != nil {
+			return nil, err
+		}
+		comments := FlacMetadataBlock{Type: FlacVorbisCommentBlock, Data: data}
+		blocks = append(blocks[:1], append([]FlacMetadataBlock{comments}, blocks[1:]...)...)
+	}
+	if !picturesWritten {
+		blocks = append(blocks, pictures...)
+	}
+	for _, b := range blocks {
+		if len(b.Data) > flacMaxBlockSize {
+			return nil, fmt.Errorf("%s block is too large", b.Type)
+		}
+	}
+	return blocks, nil
+}
+
+func writeBlocks(w io.Writer, blocks []FlacMetadataBlock) error {
+	for i, b := range blocks {
+		header := []byte{byte(b.Type), byte(len(b.Data) >> 16), byte(len(b.Data) >> 8), byte(len(b.Data))}
+		if i == len(blocks)-1 {
+			header[0] |= 0x80
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(b.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func blocksSize(blocks []FlacMetadataBlock) int64 {
+	size := int64(0)
+	for _, b := range blocks {
+		size += int64(flacBlockHeaderSize + len(b.Data))
+	}
+	return size
+}
+
+// Save metadata changes to the file.
+// If the new metadata fits in the space currently used by metadata and
+// padding, the file is updated in place. Otherwise, it is rewritten to a
+// temporary file which then replaces the original.
+// Audio frames are never modified.
+func (f *FlacFile) Save() error {
+	if f.Path == "" || len(f.Blocks) == 0 {
+		return errors.New("FLAC file was not read from disk")
+	}
+	blocks, err := f.encodeBlocks()
+	if err != nil {
+		return err
+	}
+	start := f.Blocks[0].Offset
+	available := f.AudioOffset - start
+	needed := blocksSize(blocks)
+
+	inPlace := true
+	switch {
+	case needed == available:
+	case needed+flacBlockHeaderSize <= available && available-needed-flacBlockHeaderSize <= flacMaxBlockSize:
+		padding := available - needed - flacBlockHeaderSize
+		blocks = append(blocks, FlacMetadataBlock{Type: FlacPaddingBlock, Data: make([]byte, padding)})
+	default:
+		inPlace = false
+		blocks = append(blocks, FlacMetadataBlock{Type: FlacPaddingBlock, Data: make([]byte, flacDefaultPadding)})
+	}
+
+	if inPlace {
+		err = f.writeInPlace(blocks)
+	} else {
+		err = f.rewrite(blocks)
+	}
+	if err != nil {
+		return err
+	}
+	// refresh the layout
+	updated, err := ReadFlac(f.Path)
+	if err != nil {
+		return err
+	}
+	*f = *updated
+	return nil
+}
+
+func (f *FlacFile) writeInPlace(blocks []FlacMetadataBlock) error {
+	buffer := new(bytes.Buffer)
+	if err := writeBlocks(buffer, blocks); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(buffer.Bytes(), f.Blocks[0].Offset); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FlacFile) rewrite(blocks []FlacMetadataBlock) error {
+	original, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer original.Close()
+	info, err := original.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), "."+filepath.Base(f.Path)+".")
+	if err != nil {
+		return err
+	}
+	// cleaning up if anything goes wrong
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// everything before the first block (ID3v2 tag, fLaC marker) is kept as is
+	if _, err := io.CopyN(tmp, original, f.Blocks[0].Offset); err != nil {
+		return err
+	}
+	if err := writeBlocks(tmp, blocks); err != nil {
+		return err
+	}
+	if _, err := original.Seek(f.AudioOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, original); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return err
+	}
+	tmp = nil
+	return nil
+}
+
+// WriteFlacTags replaces the given fields in a FLAC file, leaving the others untouched.
+func WriteFlacTags(path string, tags map[string][]string) error {
+	f, err := ReadFlac(path)
+	if err != nil {
+		return err
+	}
+	if f.Comments == nil {
+		f.Comments = &FlacVorbisComment{Vendor: flacVendor}
+	}
+	// sorting for a predictable tag order
+	names := []string{}
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.Comments.Set(name, tags[name]...)
+	}
+	return f.Save()
+}
@@ -0,0 +1,120 @@
+package music
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// audioMD5 of everything after the metadata blocks.
+func audioMD5(t *testing.T, path string) [16]byte {
+	f, err := ReadFlac(path)
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	return md5.Sum(data[f.AudioOffset:])
+}
+
+func TestFlacWriteTags(t *testing.T) {
+	fmt.Println("+ Testing FLAC tag writing...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := writeTestFlac(t, dir, 1024)
+	originalAudio := audioMD5(t, path)
+	originalInfo, err := os.Stat(path)
+	require.Nil(t, err)
+
+	// small changes: padding is reused, file size is unchanged
+	f, err := ReadFlac(path)
+	require.Nil(t, err)
+	f.Comments.Set("title", "Everything In Its Right Place")
+	f.Comments.Delete("GENRE")
+	f.Comments.Add("MUSICBRAINZ_ALBUMID", "a3b0e5eb-fa3b-3e4d-b5e6-d0881984a183")
+	require.Nil(t, f.Save())
+
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	check.Equal(originalInfo.Size(), info.Size(), "Tags should have been written in place")
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+
+	f, err = ReadFlac(path)
+	require.Nil(t, err)
+	check.Equal(testFlacMD5, f.StreamInfo.MD5, "STREAMINFO MD5 was modified")
+	check.Equal("Everything In Its Right Place", f.Comments.GetFirst("TITLE"))
+	check.Equal(0, len(f.Comments.Get("GENRE")))
+	check.Equal("a3b0e5eb-fa3b-3e4d-b5e6-d0881984a183", f.Comments.GetFirst("musicbrainz_albumid"))
+	check.Equal("Radiohead", f.Comments.GetFirst("ARTIST"))
+	check.True(f.Padding > 0)
+	check.Equal(1, len(f.Pictures))
+	check.NotNil(f.CueSheet)
+	check.Equal(FlacPaddingBlock, f.Blocks[len(f.Blocks)-1].Type)
+
+	// large changes: padding is not enough, the file is rewritten
+	require.Nil(t, WriteFlacTags(path, map[string][]string{
+		"COMMENT": {strings.Repeat("very long comment ", 200)},
+		"GENRE":   {"Rock", "Electronic"},
+	}))
+	info, err = os.Stat(path)
+	require.Nil(t, err)
+	check.True(info.Size() > originalInfo.Size(), "File should have been rewritten")
+	check.Equal(originalInfo.Mode(), info.Mode())
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+
+	f, err = ReadFlac(path)
+	require.Nil(t, err)
+	check.Equal(testFlacMD5, f.StreamInfo.MD5, "STREAMINFO MD5 was modified")
+	check.Equal([]string{"Rock", "Electronic"}, f.Comments.Get("GENRE"))
+	check.Equal("Everything In Its Right Place", f.Comments.GetFirst("TITLE"))
+	check.Equal(flacDefaultPadding, f.Padding)
+	check.Equal(1, len(f.Applications))
+
+	// no temporary file left behind
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	check.Equal(1, len(files))
+
+	// invalid field names are rejected, the file is left untouched
+	f.Comments.Set("NOT=VALID", "value")
+	check.NotNil(f.Save())
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+}
+
+func TestFlacWriteTagsNoPadding(t *testing.T) {
+	fmt.Println("+ Testing FLAC tag writing without padding...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := writeTestFlac(t, dir, 0)
+	originalAudio := audioMD5(t, path)
+
+	f, err := ReadFlac(path)
+	require.Nil(t, err)
+	check.Equal(0, f.Padding)
+	f.Comments = nil
+	require.Nil(t, f.Save())
+	// removing tags frees enough space for a padding block
+	check.Nil(f.Comments)
+	check.True(f.Padding > 0)
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+
+	// adding comments back to a file without VORBIS_COMMENT
+	require.Nil(t, WriteFlacTags(path, map[string][]string{"ARTIST": {"Radiohead"}}))
+	f, err = ReadFlac(path)
+	require.Nil(t, err)
+	check.Equal(FlacVorbisCommentBlock, f.Blocks[1].Type)
+	check.Equal(flacVendor, f.Comments.Vendor)
+	check.Equal("Radiohead", f.Comments.GetFirst("ARTIST"))
+	check.Equal(originalAudio, audioMD5(t, path), "Audio frames were modified")
+	check.Equal(testFlacMD5, f.StreamInfo.MD5, "STREAMINFO MD5 was modified")
+}
@@ -0,0 +1,355 @@
+package music
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ReplayGainFormat of the tags written by ApplyReplayGain.
+type ReplayGainFormat int
+
+// ReplayGain formats.
+const (
+	// ReplayGainTags are REPLAYGAIN_TRACK_GAIN/PEAK and REPLAYGAIN_ALBUM_GAIN/PEAK.
+	ReplayGainTags ReplayGainFormat = iota
+	// R128Tags are R128_TRACK_GAIN and R128_ALBUM_GAIN, as used with Opus.
+	R128Tags
+)
+
+const (
+	replayGainReference = -18.0 // LUFS, ReplayGain 2.0
+	r128Reference       = -23.0 // LUFS, EBU R128
+	absoluteGate        = -70.0 // LUFS
+	relativeGate        = -10.0 // LU
+	loudnessBlock       = 4     // 400ms gating blocks
+	loudnessStep        = 10    // of 100ms each
+)
+
+// ReplayGainUseTruePeak instead of the sample peak for REPLAYGAIN_*_PEAK tags.
+var ReplayGainUseTruePeak = false
+
+var replayGainTagNames = []string{"REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_TRACK_PEAK", "REPLAYGAIN_ALBUM_GAIN", "REPLAYGAIN_ALBUM_PEAK", "REPLAYGAIN_REFERENCE_LOUDNESS"}
+var r128TagNames = []string{"R128_TRACK_GAIN", "R128_ALBUM_GAIN"}
+
+// Loudness of a track or an album, as defined by ITU-R BS.1770.
+type Loudness struct {
+	Integrated float64 // LUFS, -70 for silence
+	SamplePeak float64 // relative to full scale
+	TruePeak   float64 // estimated by oversampling
+	blocks     []float64
+	silent     bool
+}
+
+// Silent if no block is above the absolute gate.
+func (l *Loudness) Silent() bool {
+	return l.silent
+}
+
+// ReplayGain 2.0 gain, in dB. Silence is not amplified.
+func (l *Loudness) ReplayGain() float64 {
+	if l.silent {
+		return 0
+	}
+	return replayGainReference - l.Integrated
+}
+
+// R128Gain relative to -23 LUFS, in Q7.8 fixed point. Silence is not amplified.
+func (l *Loudness) R128Gain() int {
+	if l.silent {
+		return 0
+	}
+	gain := math.Floor((r128Reference-l.Integrated)*256 + 0.5)
+	return int(math.Max(math.Min(gain, math.MaxInt16), math.MinInt16))
+}
+
+// Peak used in ReplayGain tags.
+func (l *Loudness) Peak() float64 {
+	if ReplayGainUseTruePeak {
+		return l.TruePeak
+	}
+	return l.SamplePeak
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+// gate the blocks and compute the integrated loudness.
+func (l *Loudness) gate() {
+	threshold := math.Pow(10, (absoluteGate+0.691)/10)
+	mean := func(min float64) (float64, int) {
+		sum, count := 0.0, 0
+		for _, p := range l.blocks {
+			if p > min {
+				sum += p
+				count++
+			}
+		}
+		if count == 0 {
+			return 0, 0
+		}
+		return sum / float64(count), count
+	}
+	power, count := mean(threshold)
+	l.silent = count == 0
+	if l.silent {
+		l.Integrated = absoluteGate
+		return
+	}
+	threshold = math.Max(threshold, power*math.Pow(10, relativeGate/10))
+	power, _ = mean(threshold)
+	l.Integrated = blockLoudness(power)
+}
+
+// AlbumLoudness from the loudness of all its tracks.
+func AlbumLoudness(tracks []*Loudness) *Loudness {
+	album := &Loudness{}
+	for _, t := range tracks {
+		album.blocks = append(album.blocks, t.blocks...)
+		album.SamplePeak = math.Max(album.SamplePeak, t.SamplePeak)
+		album.TruePeak = math.Max(album.TruePeak, t.TruePeak)
+	}
+	album.gate()
+	return album
+}
+
+// MeasureLoudness of a FLAC file.
+func MeasureLoudness(path string) (*Loudness, error) {
+	d, err := OpenFlacDecoder(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	m := newLoudnessMeter(int(d.Info.SampleRate), int(d.Info.Channels))
+	scale := 1 / float64(uint64(1)<<(d.Info.BitsPerSample-1))
+	for {
+		frame, err := d.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		m.add(frame.Samples, scale)
+	}
+	return m.loudness(), nil
+}
+
+// ApplyReplayGain measures the loudness of the tracks of an album, and tags them.
+// Tags of the other format are removed.
+func ApplyReplayGain(tracks []LocalTrack, format ReplayGainFormat) ([]*Loudness, *Loudness, error) {
+	measures := []*Loudness{}
+	for _, t := range tracks {
+		l, err := MeasureLoudness(t.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		measures = append(measures, l)
+	}
+	album := AlbumLoudness(measures)
+	for i, t := range tracks {
+		if err := writeReplayGainTags(t.Path, replayGainTags(measures[i], album, format), format); err != nil {
+			return nil, nil, err
+		}
+	}
+	return measures, album, nil
+}
+
+func replayGainTags(track, album *Loudness, format ReplayGainFormat) map[string]string {
+	if format == R128Tags {
+		return map[string]string{
+			"R128_TRACK_GAIN": strconv.Itoa(track.R128Gain()),
+			"R128_ALBUM_GAIN": strconv.Itoa(album.R128Gain()),
+		}
+	}
+	return map[string]string{
+		"REPLAYGAIN_TRACK_GAIN":         fmt.Sprintf("%.2f dB", track.ReplayGain()),
+		"REPLAYGAIN_TRACK_PEAK":         fmt.Sprintf("%.6f", track.Peak()),
+		"REPLAYGAIN_ALBUM_GAIN":         fmt.Sprintf("%.2f dB", album.ReplayGain()),
+		"REPLAYGAIN_ALBUM_PEAK":         fmt.Sprintf("%.6f", album.Peak()),
+		"REPLAYGAIN_REFERENCE_LOUDNESS": fmt.Sprintf("%.2f LUFS", replayGainReference),
+	}
+}
+
+func writeReplayGainTags(path string, tags map[string]string, format ReplayGainFormat) error {
+	f, err := ReadFlac(path)
+	if err != nil {
+		return err
+	}
+	if f.Comments == nil {
+		f.Comments = &FlacVorbisComment{Vendor: flacVendor}
+	}
+	stale := r128TagNames
+	if format == R128Tags {
+		stale = replayGainTagNames
+	}
+	for _, name := range stale {
+		f.Comments.Delete(name)
+	}
+	for _, name := range append(replayGainTagNames, r128TagNames...) {
+		if value, ok := tags[name]; ok {
+			f.Comments.Set(name, value)
+		}
+	}
+	return f.Save()
+}
+
+//------------------------
+
+// biquad filter, in direct form II transposed.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting filters for a sample rate: a high shelf, then a high pass.
+func kWeighting(sampleRate float64) (biquad, biquad) {
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+	return shelf, highPass
+}
+
+// loudnessMeter accumulates K-weighted energy in 100ms steps.
+type loudnessMeter struct {
+	weights      []float64
+	shelf        []biquad
+	highPass     []biquad
+	peak         *truePeakMeter
+	stepLength   int
+	stepPosition int
+	stepEnergy   float64
+	steps        []float64 // mean weighted energy of each step
+	samplePeak   float64
+}
+
+func newLoudnessMeter(sampleRate, channels int) *loudnessMeter {
+	m := &loudnessMeter{stepLength: int(math.Floor(float64(sampleRate)/loudnessStep + 0.5))}
+	for c := 0; c < channels; c++ {
+		shelf, highPass := kWeighting(float64(sampleRate))
+		m.shelf = append(m.shelf, shelf)
+		m.highPass = append(m.highPass, highPass)
+		m.weights = append(m.weights, channelWeight(channels, c))
+	}
+	m.peak = newTruePeakMeter(sampleRate, channels)
+	return m
+}
+
+// channelWeight in the FLAC channel order: the LFE channel is ignored, surround channels weigh more.
+func channelWeight(channels, c int) float64 {
+	switch {
+	case channels == 4 && c >= 2: // FL FR BL BR
+		return 1.41
+	case channels == 5 && c >= 3: // FL FR FC BL BR
+		return 1.41
+	case channels >= 6 && c == 3: // FL FR FC LFE BL BR, and more surround channels
+		return 0
+	case channels >= 6 && c >= 4:
+		return 1.41
+	}
+	return 1
+}
+
+func (m *loudnessMeter) add(samples [][]int32, scale float64) {
+	for i := range samples[0] {
+		for c, channel := range samples {
+			x := float64(channel[i]) * scale
+			m.samplePeak = math.Max(m.samplePeak, math.Abs(x))
+			m.peak.add(c, x)
+			y := m.highPass[c].process(m.shelf[c].process(x))
+			m.stepEnergy += m.weights[c] * y * y
+		}
+		m.stepPosition++
+		if m.stepPosition == m.stepLength {
+			m.steps = append(m.steps, m.stepEnergy/float64(m.stepLength))
+			m.stepPosition, m.stepEnergy = 0, 0
+		}
+	}
+}
+
+func (m *loudnessMeter) loudness() *Loudness {
+	l := &Loudness{SamplePeak: m.samplePeak, TruePeak: math.Max(m.peak.peak, m.samplePeak)}
+	for i := 0; i+loudnessBlock <= len(m.steps); i++ {
+		power := 0.0
+		for _, e := range m.steps[i : i+loudnessBlock] {
+			power += e
+		}
+		l.blocks = append(l.blocks, power/loudnessBlock)
+	}
+	l.gate()
+	return l
+}
+
+// truePeakMeter oversamples the signal to find peaks between samples.
+type truePeakMeter struct {
+	factor  int
+	filters [][]float64 // one per phase
+	history [][]float64 // last input samples of each channel, most recent first
+	peak    float64
+}
+
+func newTruePeakMeter(sampleRate, channels int) *truePeakMeter {
+	m := &truePeakMeter{factor: 4}
+	switch {
+	case sampleRate >= 192000:
+		m.factor = 1
+	case sampleRate >= 96000:
+		m.factor = 2
+	}
+	taps := 12 * m.factor
+	m.filters = make([][]float64, m.factor)
+	for j := 0; j < taps; j++ {
+		// windowed sinc, centered between the taps
+		x := (float64(j) - float64(taps-1)/2) / float64(m.factor)
+		c := 1.0
+		if x != 0 {
+			c = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		c *= 0.5 * (1 - math.Cos(2*math.Pi*float64(j)/float64(taps-1)))
+		m.filters[j%m.factor] = append(m.filters[j%m.factor], c)
+	}
+	m.history = make([][]float64, channels)
+	for c := range m.history {
+		m.history[c] = make([]float64, taps/m.factor)
+	}
+	return m
+}
+
+func (m *truePeakMeter) add(channel int, x float64) {
+	h := m.history[channel]
+	copy(h[1:], h)
+	h[0] = x
+	if m.factor == 1 {
+		m.peak = math.Max(m.peak, math.Abs(x))
+		return
+	}
+	for _, filter := range m.filters {
+		y := 0.0
+		for k, c := range filter {
+			y += c * h[k]
+		}
+		m.peak = math.Max(m.peak, math.Abs(y))
+	}
+}
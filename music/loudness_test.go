@@ -0,0 +1,144 @@
+package music
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTone of a given level in dBFS, in 16-bit samples.
+func testTone(seconds float64, frequency float64, sampleRate int, dbfs float64, phase float64) []int32 {
+	amplitude := math.Pow(10, dbfs/20) * 32768
+	samples := make([]int32, int(seconds*float64(sampleRate)))
+	for i := range samples {
+		samples[i] = int32(math.Floor(amplitude*math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate)+phase) + 0.5))
+	}
+	return samples
+}
+
+func testMeasure(sampleRate int, channels ...[]int32) *Loudness {
+	m := newLoudnessMeter(sampleRate, len(channels))
+	m.add(channels, 1.0/32768)
+	return m.loudness()
+}
+
+func TestLoudness(t *testing.T) {
+	fmt.Println("+ Testing loudness measurement...")
+	check := assert.New(t)
+
+	// EBU Tech 3341, case 1: stereo 1 kHz at -23 dBFS is -23 LUFS
+	for _, rate := range []int{44100, 48000, 96000} {
+		tone := testTone(5, 1000, rate, -23, 0)
+		l := testMeasure(rate, tone, tone)
+		check.InDelta(-23.0, l.Integrated, 0.1, "at %d Hz", rate)
+		check.InDelta(5.0, l.ReplayGain(), 0.1)
+		check.InDelta(0, l.R128Gain(), 26)
+		check.InDelta(math.Pow(10, -23.0/20), l.SamplePeak, 0.001)
+	}
+
+	// silence and quiet parts are gated
+	loud := testTone(10, 1000, 48000, -23, 0)
+	quiet := append(append([]int32{}, loud...), testTone(10, 1000, 48000, -36, 0)...)
+	quiet = append(quiet, make([]int32, 48000*10)...)
+	l := testMeasure(48000, quiet, quiet)
+	check.InDelta(-23.0, l.Integrated, 0.1)
+
+	silence := make([]int32, 48000*5)
+	l = testMeasure(48000, silence)
+	check.Equal(-70.0, l.Integrated)
+	check.Equal(0.0, l.SamplePeak)
+	check.True(l.Silent())
+	check.Equal(0.0, l.ReplayGain(), "Silence is not amplified")
+	check.Equal(0, l.R128Gain())
+	check.True(AlbumLoudness([]*Loudness{l}).Silent())
+	check.False(AlbumLoudness([]*Loudness{l, testMeasure(48000, loud)}).Silent())
+
+	// the LFE channel does not count, surround channels do more
+	mute := make([]int32, len(loud))
+	l = testMeasure(48000, mute, mute, mute, loud, mute, mute)
+	check.Equal(-70.0, l.Integrated)
+	l = testMeasure(48000, mute, mute, mute, mute, loud, mute)
+	check.InDelta(-26.0+1.5, l.Integrated, 0.1)
+	// in every layout
+	for channels, surround := range map[int][]int{4: {2, 3}, 5: {3, 4}, 6: {4, 5}, 8: {4, 5, 6, 7}} {
+		for c := 0; c < channels; c++ {
+			weight := 1.0
+			for _, s := range surround {
+				if c == s {
+					weight = 1.41
+				}
+			}
+			if channels >= 6 && c == 3 {
+				weight = 0
+			}
+			check.Equal(weight, channelWeight(channels, c), "channel %d of %d", c, channels)
+		}
+	}
+	l = testMeasure(48000, mute, mute, mute, loud)
+	check.InDelta(-26.0+1.5, l.Integrated, 0.1, "Quad back channel")
+	l = testMeasure(48000, mute, mute, mute, loud, mute)
+	check.InDelta(-26.0+1.5, l.Integrated, 0.1, "5.0 surround channel")
+
+	// album loudness is measured on all blocks together
+	other := testTone(10, 1000, 48000, -33, 0)
+	album := AlbumLoudness([]*Loudness{testMeasure(48000, loud, loud), testMeasure(48000, other, other)})
+	check.InDelta(-23.0+10*math.Log10(0.55), album.Integrated, 0.1)
+	check.InDelta(math.Pow(10, -23.0/20), album.SamplePeak, 0.001)
+
+	// peaks between samples
+	tone := testTone(1, 11025, 44100, -6, math.Pi/4)
+	l = testMeasure(44100, tone)
+	check.InDelta(0.5*math.Sqrt2/2, l.SamplePeak, 0.001)
+	check.InDelta(0.5, l.TruePeak, 0.02)
+}
+
+func TestApplyReplayGain(t *testing.T) {
+	fmt.Println("+ Testing ReplayGain tagging...")
+	check := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	loud, quiet := testTone(10, 1000, 44100, -23, 0), testTone(10, 1000, 44100, -33, 0)
+	writeTestAudioFlac(t, filepath.Join(dir, "01.flac"), 44100, [][]int32{loud, loud})
+	writeTestAudioFlac(t, filepath.Join(dir, "02.flac"), 44100, [][]int32{quiet, quiet})
+	require.Nil(t, WriteFlacTags(filepath.Join(dir, "01.flac"), map[string][]string{"R128_TRACK_GAIN": {"12"}, "TITLE": {"Tone"}}))
+
+	tracks, err := ReadLocalAlbum(dir)
+	require.Nil(t, err)
+	measures, album, err := ApplyReplayGain(tracks, ReplayGainTags)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(measures))
+
+	f, err := ReadFlac(filepath.Join(dir, "01.flac"))
+	require.Nil(t, err)
+	check.Equal("Tone", f.Comments.GetFirst("TITLE"))
+	check.Equal("", f.Comments.GetFirst("R128_TRACK_GAIN"))
+	check.Equal(fmt.Sprintf("%.2f dB", measures[0].ReplayGain()), f.Comments.GetFirst("REPLAYGAIN_TRACK_GAIN"))
+	check.Equal(fmt.Sprintf("%.2f dB", album.ReplayGain()), f.Comments.GetFirst("REPLAYGAIN_ALBUM_GAIN"))
+	check.Equal("-18.00 LUFS", f.Comments.GetFirst("REPLAYGAIN_REFERENCE_LOUDNESS"))
+	gain, err := strconv.ParseFloat(strings.TrimSuffix(f.Comments.GetFirst("REPLAYGAIN_TRACK_GAIN"), " dB"), 64)
+	require.Nil(t, err)
+	check.InDelta(5.0, gain, 0.1)
+	peak, err := strconv.ParseFloat(f.Comments.GetFirst("REPLAYGAIN_ALBUM_PEAK"), 64)
+	require.Nil(t, err)
+	check.InDelta(math.Pow(10, -23.0/20), peak, 0.001)
+
+	_, _, err = ApplyReplayGain(tracks, R128Tags)
+	require.Nil(t, err)
+	f, err = ReadFlac(filepath.Join(dir, "02.flac"))
+	require.Nil(t, err)
+	check.Equal("", f.Comments.GetFirst("REPLAYGAIN_TRACK_GAIN"))
+	check.Equal("", f.Comments.GetFirst("REPLAYGAIN_REFERENCE_LOUDNESS"))
+	check.Equal(strconv.Itoa(measures[1].R128Gain()), f.Comments.GetFirst("R128_TRACK_GAIN"))
+	check.Equal(strconv.Itoa(album.R128Gain()), f.Comments.GetFirst("R128_ALBUM_GAIN"))
+	check.InDelta(10*256, measures[1].R128Gain(), 26)
+}
@@ -0,0 +1,348 @@
+package music
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Match components.
+const (
+	TrackCountComponent = "track count"
+	DurationComponent   = "durations"
+	TrackTitleComponent = "track titles"
+	AlbumTitleComponent = "album title"
+	ArtistComponent     = "artist"
+	YearComponent       = "year"
+	LabelComponent      = "label"
+	MediaCountComponent = "media count"
+)
+
+const (
+	maxDurationDelta = 30 * time.Second
+	maxYearDelta     = 10
+	flacExtension    = ".flac"
+)
+
+// MatchWeights of each component in the overall distance.
+var MatchWeights = map[string]float64{
+	TrackCountComponent: 3,
+	DurationComponent:   4,
+	TrackTitleComponent: 2,
+	AlbumTitleComponent: 3,
+	ArtistComponent:     3,
+	YearComponent:       1,
+	LabelComponent:      1,
+	MediaCountComponent: 1,
+}
+
+// LocalTrack is what is known about a local file before tagging.
+type LocalTrack struct {
+	Path          string
+	Duration      time.Duration
+	Title         string
+	Artist        string
+	Album         string
+	AlbumArtist   string
+	TrackNumber   int
+	DiscNumber    int
+	Year          int
+	Label         string
+	CatalogNumber string
+	RecordingID   string // MusicBrainz recording, from tags or AcoustID
+}
+
+// NewLocalTrack from the metadata of a FLAC file.
+func NewLocalTrack(f *FlacFile) LocalTrack {
+	t := LocalTrack{Path: f.Path, Duration: f.StreamInfo.Duration()}
+	if f.Comments == nil {
+		return t
+	}
+	c := f.Comments
+	t.Title = c.GetFirst("TITLE")
+	t.Artist = c.GetFirst("ARTIST")
+	t.Album = c.GetFirst("ALBUM")
+	t.AlbumArtist = c.GetFirst("ALBUMARTIST")
+	t.TrackNumber = parseTagNumber(c.GetFirst("TRACKNUMBER"))
+	t.DiscNumber = parseTagNumber(c.GetFirst("DISCNUMBER"))
+	t.Year = parseYear(c.GetFirst("DATE"))
+	t.Label = c.GetFirst("LABEL")
+	t.CatalogNumber = c.GetFirst("CATALOGNUMBER")
+	t.RecordingID = c.GetFirst("MUSICBRAINZ_TRACKID")
+	return t
+}
+
+// parseTagNumber from "3" or "3/12".
+func parseTagNumber(value string) int {
+	value = strings.TrimSpace(strings.SplitN(value, "/", 2)[0])
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// ReadLocalAlbum reads all FLAC files in a directory, sorted by disc,
+// track number, then path.
+func ReadLocalAlbum(dir string) ([]LocalTrack, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	tracks := []LocalTrack{}
+	for _, file := range files {
+		if file.IsDir() || strings.ToLower(filepath.Ext(file.Name())) != flacExtension {
+			continue
+		}
+		f, err := ReadFlac(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, NewLocalTrack(f))
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("No FLAC file in " + dir)
+	}
+	sortLocalTracks(tracks)
+	return tracks, nil
+}
+
+func sortLocalTracks(tracks []LocalTrack) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Path < b.Path
+	})
+}
+
+// Match of a candidate release against local files.
+// Distances are between 0 (perfect match) and 1.
+type Match struct {
+	Release    *Release
+	Distance   float64
+	Components map[string]float64
+}
+
+// MatchReleases scores candidates against local tracks, best match first.
+// Components that cannot be evaluated (missing tags or release information)
+// are left out of the distance.
+func MatchReleases(tracks []LocalTrack, candidates []*Release) []Match {
+	matches := []Match{}
+	for _, r := range candidates {
+		matches = append(matches, MatchRelease(tracks, r))
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+	return matches
+}
+
+// MatchRelease computes the distance between local tracks and a release.
+// Tracks are paired in order.
+func MatchRelease(tracks []LocalTrack, r *Release) Match {
+	m := Match{Release: r, Components: map[string]float64{}}
+	releaseTracks := r.Tracks()
+	album := localAlbumInfo(tracks)
+
+	// search hits only have track counts
+	if count := r.TrackCount(); count != 0 {
+		m.Components[TrackCountComponent] = math.Min(math.Abs(float64(count-len(tracks)))/math.Max(float64(len(tracks)), 1), 1)
+	}
+	if len(releaseTracks) != 0 {
+		durations, titles := []float64{}, []float64{}
+		for i := 0; i < len(tracks) && i < len(releaseTracks); i++ {
+			if d, ok := durationDistance(tracks[i].Duration, releaseTracks[i].Length); ok {
+				durations = append(durations, d)
+			}
+			if tracks[i].Title != "" {
+				titles = append(titles, 1-stringSimilarity(tracks[i].Title, releaseTracks[i].Title))
+			}
+		}
+		setMean(m.Components, DurationComponent, durations)
+		setMean(m.Components, TrackTitleComponent, titles)
+	}
+	if album.title != "" && r.Title != "" {
+		m.Components[AlbumTitleComponent] = 1 - stringSimilarity(album.title, r.Title)
+	}
+	if album.artist != "" && len(r.Artists) != 0 {
+		m.Components[ArtistComponent] = 1 - stringSimilarity(album.artist, r.Artists.String())
+	}
+	if album.year != 0 && r.Year != 0 {
+		m.Components[YearComponent] = math.Min(math.Abs(float64(album.year-r.Year))/maxYearDelta, 1)
+	}
+	if d, ok := labelDistance(album.label, album.catalogNumber, r.Labels); ok {
+		m.Components[LabelComponent] = d
+	}
+	if len(r.Media) != 0 {
+		if album.media == len(r.Media) {
+			m.Components[MediaCountComponent] = 0
+		} else {
+			m.Components[MediaCountComponent] = 1
+		}
+	}
+
+	total, weights := 0.0, 0.0
+	for component, distance := range m.Components {
+		total += distance * MatchWeights[component]
+		weights += MatchWeights[component]
+	}
+	if weights == 0 {
+		m.Distance = 1
+	} else {
+		m.Distance = total / weights
+	}
+	return m
+}
+
+func setMean(components map[string]float64, component string, values []float64) {
+	if len(values) == 0 {
+		return
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	components[component] = sum / float64(len(values))
+}
+
+// durationDistance, if both durations are known.
+func durationDistance(local, release time.Duration) (float64, bool) {
+	if local == 0 || release == 0 {
+		return 0, false
+	}
+	delta := local - release
+	if delta < 0 {
+		delta = -delta
+	}
+	return math.Min(float64(delta)/float64(maxDurationDelta), 1), true
+}
+
+// labelDistance, using the closest label name or catalog number, if any can be compared.
+func labelDistance(label, catalogNumber string, labels []LabelInfo) (float64, bool) {
+	rp := strings.NewReplacer(" ", "", "-", "", ".", "")
+	best, compared := 1.0, false
+	for _, l := range labels {
+		distances := []float64{}
+		if label != "" && l.Name != "" {
+			distances = append(distances, 1-stringSimilarity(label, l.Name))
+		}
+		if catalogNumber != "" && l.CatalogNumber != "" {
+			distances = append(distances, 1-stringSimilarity(rp.Replace(catalogNumber), rp.Replace(l.CatalogNumber)))
+		}
+		for _, d := range distances {
+			best, compared = math.Min(best, d), true
+		}
+	}
+	return best, compared
+}
+
+type localAlbum struct {
+	title, artist, label, catalogNumber string
+	year, media                         int
+}
+
+// localAlbumInfo from the most common tag values.
+func localAlbumInfo(tracks []LocalTrack) localAlbum {
+	album := localAlbum{}
+	titles, artists, labels, catnos, years := map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}, map[string]int{}
+	discs := map[int]bool{}
+	for _, t := range tracks {
+		titles[t.Album]++
+		if t.AlbumArtist != "" {
+			artists[t.AlbumArtist]++
+		} else {
+			artists[t.Artist]++
+		}
+		labels[t.Label]++
+		catnos[t.CatalogNumber]++
+		if t.Year != 0 {
+			years[strconv.Itoa(t.Year)]++
+		}
+		if t.DiscNumber != 0 {
+			discs[t.DiscNumber] = true
+		}
+	}
+	album.title = mostCommon(titles)
+	album.artist = mostCommon(artists)
+	album.label = mostCommon(labels)
+	album.catalogNumber = mostCommon(catnos)
+	album.year, _ = strconv.Atoi(mostCommon(years))
+	album.media = len(discs)
+	if album.media == 0 {
+		album.media = 1
+	}
+	return album
+}
+
+// mostCommon non-empty value, ties broken alphabetically.
+func mostCommon(counts map[string]int) string {
+	best, bestCount := "", 0
+	for value, count := range counts {
+		if value == "" {
+			continue
+		}
+		if count > bestCount || (count == bestCount && value < best) {
+			best, bestCount = value, count
+		}
+	}
+	return best
+}
+
+// normalize a string before comparison: lower case, no punctuation, single spaces.
+func normalize(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+// levenshtein distance between two strings, in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// stringSimilarity between 0 (nothing in common) and 1 (identical once normalized),
+// from the normalized Levenshtein distance.
+func stringSimilarity(a, b string) float64 {
+	a, b = normalize(a), normalize(b)
+	length := math.Max(float64(len([]rune(a))), float64(len([]rune(b))))
+	if length == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/length
+}
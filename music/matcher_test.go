@@ -0,0 +1,118 @@
+package music
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testLocalFile struct {
+	name     string
+	duration int // seconds
+	tags     []string
+}
+
+// writeTestAlbum as a directory of tagged FLAC files, with fake audio.
+func writeTestAlbum(t *testing.T, files []testLocalFile) string {
+	dir, err := ioutil.TempDir("", "aubergine")
+	require.Nil(t, err)
+	for _, f := range files {
+		data := testFlacBytes([]testFlacBlock{
+			{FlacStreamInfoBlock, testStreamInfo(44100, 2, 16, uint64(f.duration)*44100, testFlacMD5)},
+			{FlacVorbisCommentBlock, testVorbisComment("test", f.tags...)},
+		}, testFlacAudio())
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, f.name), data, 0644))
+	}
+	return dir
+}
+
+func testRelease(id string, year int, catno string, durations ...int) *Release {
+	r := &Release{
+		ID:      id,
+		Title:   "Kid A",
+		Artists: ArtistCredits{{Name: "Radiohead"}},
+		Year:    year,
+		Labels:  []LabelInfo{{Name: "Parlophone", CatalogNumber: catno}},
+		Media:   []Medium{{Position: 1, Format: "CD"}},
+	}
+	titles := []string{"Everything in Its Right Place", "Kid A", "The National Anthem", "How to Disappear Completely"}
+	for i, d := range durations {
+		r.Media[0].Tracks = append(r.Media[0].Tracks, Track{Position: i + 1, Title: titles[i%len(titles)], Length: time.Duration(d) * time.Second})
+	}
+	return r
+}
+
+func TestMatcher(t *testing.T) {
+	fmt.Println("+ Testing release matching...")
+	check := assert.New(t)
+
+	dir := writeTestAlbum(t, []testLocalFile{
+		{"b.flac", 284, []string{"TITLE=Kid A", "ARTIST=Radiohead", "ALBUM=Kid A", "TRACKNUMBER=2/3", "DATE=2000-10-02", "CATALOGNUMBER=5277532"}},
+		{"a.flac", 251, []string{"TITLE=Everything In Its Right Place", "ARTIST=Radiohead", "ALBUM=Kid A", "TRACKNUMBER=1/3", "DATE=2000"}},
+		{"c.flac", 351, []string{"TITLE=The National Anthem", "ARTIST=Radiohead", "ALBUM=Kid A", "TRACKNUMBER=3/3", "DATE=2000"}},
+		{"cover.jpg", 0, nil},
+	})
+	defer os.RemoveAll(dir)
+
+	tracks, err := ReadLocalAlbum(dir)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(tracks))
+	check.Equal(filepath.Join(dir, "a.flac"), tracks[0].Path)
+	check.Equal(2, tracks[1].TrackNumber)
+	check.Equal(284*time.Second, tracks[1].Duration)
+	check.Equal(2000, tracks[1].Year)
+
+	good := testRelease("good", 2000, "527 7532", 251, 285, 350)
+	reissue := testRelease("reissue", 2009, "", 251, 285, 350)
+	wrongLengths := testRelease("lengths", 2000, "527 7532", 200, 200, 200)
+	tooLong := testRelease("long", 2000, "527 7532", 251, 285, 350, 360)
+	noTracks := testRelease("search hit", 2000, "527 7532")
+
+	matches := MatchReleases(tracks, []*Release{tooLong, wrongLengths, reissue, good})
+	require.Equal(t, 4, len(matches))
+	check.Equal("good", matches[0].Release.ID)
+	check.True(matches[0].Distance < 0.05, "Expected a near perfect match: %v", matches[0].Components)
+	check.Equal(0.0, matches[0].Components[LabelComponent])
+	check.Equal(0.0, matches[0].Components[AlbumTitleComponent])
+	check.Equal(0.0, matches[0].Components[MediaCountComponent])
+	check.InDelta(2.0/30/3, matches[0].Components[DurationComponent], 0.001)
+
+	reissueMatch := MatchRelease(tracks, reissue)
+	check.Equal(0.9, reissueMatch.Components[YearComponent])
+	_, ok := reissueMatch.Components[LabelComponent]
+	check.False(ok, "Release without label information")
+
+	check.Equal(1.0/3, MatchRelease(tracks, tooLong).Components[TrackCountComponent])
+	searchHit := &Release{ID: "hit", Media: []Medium{{Position: 1, TrackCount: 4}}}
+	check.Equal(1.0/3, MatchRelease(tracks, searchHit).Components[TrackCountComponent], "Search hits only have track counts")
+	_, ok = MatchRelease(tracks, searchHit).Components[DurationComponent]
+	check.False(ok)
+	check.True(MatchRelease(tracks, wrongLengths).Components[DurationComponent] > 0.9)
+	_, ok = MatchRelease(tracks, noTracks).Components[DurationComponent]
+	check.False(ok, "Durations cannot be compared without a tracklist")
+	check.Equal("lengths", matches[3].Release.ID)
+
+	_, err = ReadLocalAlbum(os.TempDir() + "/does-not-exist")
+	check.NotNil(err)
+}
+
+func TestStringSimilarity(t *testing.T) {
+	fmt.Println("+ Testing string similarity...")
+	check := assert.New(t)
+
+	check.Equal(0, levenshtein("kitten", "kitten"))
+	check.Equal(3, levenshtein("kitten", "sitting"))
+	check.Equal(6, levenshtein("", "sitten"))
+	check.Equal(1, levenshtein("café", "cafe"))
+	check.Equal(1.0, stringSimilarity("Everything In Its Right Place", "everything in its right place!"))
+	check.Equal(1.0, stringSimilarity("", ""))
+	check.Equal(0.0, stringSimilarity("abc", "xyz"))
+	check.InDelta(0.8, stringSimilarity("Kid A.", "Kid B"), 0.001)
+	check.Equal("the national anthem", normalize("  The National-Anthem "))
+}
@@ -1,30 +1,132 @@
 package music
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 const (
-	musicBrainzAPIURL = "http://musicbrainz.org/ws/2/release/%s?inc=labels+artist-credits&fmt=json"
+	musicBrainzURL        = "http://musicbrainz.org/ws/2"
+	musicBrainzReleaseURL = "/release/%s?inc=labels+artist-credits+recordings+media+release-groups+isrcs&fmt=json"
 )
 
+var (
+	// MusicBrainzOptions for new MusicBrainz releases and searches.
+	MusicBrainzOptions = ProviderOptions{}
+	// MusicBrainzUserAgent identifies the application, as required by MusicBrainz,
+	// unless the options set one.
+	MusicBrainzUserAgent = UserAgent
+	// MusicBrainzRateLimiter is shared by all MusicBrainz requests.
+	// MusicBrainz allows one request per second per client.
+	MusicBrainzRateLimiter = NewRateLimiter(time.Second, 1)
+	// MusicBrainzMaxRetries when MusicBrainz is overloaded.
+	MusicBrainzMaxRetries = 5
+
+	// musicBrainzRetryDelay without Retry-After header, doubled after each attempt.
+	musicBrainzRetryDelay = time.Second
+)
+
+// musicBrainzGet is used for all requests to MusicBrainz, so that they are
+// rate limited, identified, and retried if the server is overloaded.
+func musicBrainzGet(ctx context.Context, options ProviderOptions, url string) ([]byte, error) {
+	client := options.httpClient(MusicBrainzRateLimiter)
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", options.userAgent(MusicBrainzUserAgent))
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if shouldRetry(resp) && attempt < MusicBrainzMaxRetries {
+			resp.Body.Close()
+			if err := sleepContext(ctx, retryDelay(resp, musicBrainzRetryDelay<<uint(attempt))); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("Returned status: " + resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+}
+
+// MusicBrainzArtistCredit is the list of artists credited for a release, a track or a recording.
+type MusicBrainzArtistCredit []struct {
+	Artist struct {
+		Disambiguation string `json:"disambiguation"`
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		SortName       string `json:"sort-name"`
+	} `json:"artist"`
+	Joinphrase string `json:"joinphrase"`
+	Name       string `json:"name"`
+}
+
+// String representation of the credit, as it should be displayed.
+func (ac MusicBrainzArtistCredit) String() string {
+	credit := ""
+	for _, a := range ac {
+		credit += a.Name + a.Joinphrase
+	}
+	return credit
+}
+
+// MusicBrainzTrack is a track of a release medium, and the recording it is based on.
+type MusicBrainzTrack struct {
+	ArtistCredit MusicBrainzArtistCredit `json:"artist-credit"`
+	ID           string                  `json:"id"`
+	Length       int                     `json:"length"`
+	Number       string                  `json:"number"`
+	Position     int                     `json:"position"`
+	Recording    struct {
+		ArtistCredit   MusicBrainzArtistCredit `json:"artist-credit"`
+		Disambiguation string                  `json:"disambiguation"`
+		ID             string                  `json:"id"`
+		Isrcs          []string                `json:"isrcs"`
+		Length         int                     `json:"length"`
+		Title          string                  `json:"title"`
+		Video          bool                    `json:"video"`
+	} `json:"recording"`
+	Title string `json:"title"`
+}
+
+// Duration of the track, falling back to the recording length.
+func (t MusicBrainzTrack) Duration() time.Duration {
+	length := t.Length
+	if length == 0 {
+		length = t.Recording.Length
+	}
+	return time.Duration(length) * time.Millisecond
+}
+
+// MusicBrainzMedium is a disc (or side, or file set) of a release.
+type MusicBrainzMedium struct {
+	Format      string             `json:"format"`
+	FormatID    string             `json:"format-id"`
+	Position    int                `json:"position"`
+	Title       string             `json:"title"`
+	TrackCount  int                `json:"track-count"`
+	TrackOffset int                `json:"track-offset"`
+	Tracks      []MusicBrainzTrack `json:"tracks"`
+}
+
 // MusicBrainzReleaseResults is a struct describing the JSON response for a MusicBreinz query about a speficif release.
 type MusicBrainzReleaseResults struct {
-	ArtistCredit []struct {
-		Artist struct {
-			Disambiguation string `json:"disambiguation"`
-			ID             string `json:"id"`
-			Name           string `json:"name"`
-			SortName       string `json:"sort-name"`
-		} `json:"artist"`
-		Joinphrase string `json:"joinphrase"`
-		Name       string `json:"name"`
-	} `json:"artist-credit"`
-	Asin            string `json:"asin"`
-	Barcode         string `json:"barcode"`
-	Country         string `json:"country"`
+	ArtistCredit    MusicBrainzArtistCredit `json:"artist-credit"`
+	Asin            string                  `json:"asin"`
+	Barcode         string                  `json:"barcode"`
+	Country         string                  `json:"country"`
 	CoverArtArchive struct {
 		Artwork  bool `json:"artwork"`
 		Back     bool `json:"back"`
@@ -45,9 +147,19 @@ type MusicBrainzReleaseResults struct {
 			SortName       string      `json:"sort-name"`
 		} `json:"label"`
 	} `json:"label-info"`
-	Packaging     string `json:"packaging"`
-	PackagingID   string `json:"packaging-id"`
-	Quality       string `json:"quality"`
+	Media        []MusicBrainzMedium `json:"media"`
+	Packaging    string              `json:"packaging"`
+	PackagingID  string              `json:"packaging-id"`
+	Quality      string              `json:"quality"`
+	ReleaseGroup struct {
+		ArtistCredit     MusicBrainzArtistCredit `json:"artist-credit"`
+		Disambiguation   string                  `json:"disambiguation"`
+		FirstReleaseDate string                  `json:"first-release-date"`
+		ID               string                  `json:"id"`
+		PrimaryType      string                  `json:"primary-type"`
+		SecondaryTypes   []string                `json:"secondary-types"`
+		Title            string                  `json:"title"`
+	} `json:"release-group"`
 	ReleaseEvents []struct {
 		Area struct {
 			Disambiguation string   `json:"disambiguation"`
@@ -69,23 +181,52 @@ type MusicBrainzReleaseResults struct {
 
 // MusicBrainzRelease allows retrieving information from MusicBrainz
 type MusicBrainzRelease struct {
-	ID   string
-	Info MusicBrainzReleaseResults
+	ID      string
+	Options ProviderOptions
+	Info    MusicBrainzReleaseResults
 }
 
 // NewMusicBrainzRelease set up with release ID
 func NewMusicBrainzRelease(id string) *MusicBrainzRelease {
-	return &MusicBrainzRelease{ID: id}
+	return &MusicBrainzRelease{ID: id, Options: MusicBrainzOptions}
 }
 
 // GetInfo from MusicBrainz about a release
 func (mb *MusicBrainzRelease) GetInfo() error {
+	return mb.GetInfoContext(context.Background())
+}
+
+// GetInfoContext from MusicBrainz about a release, until the context is done.
+func (mb *MusicBrainzRelease) GetInfoContext(ctx context.Context) error {
 	// musicbrainz lookup
-	musicbrainzSearch := fmt.Sprintf(musicBrainzAPIURL, mb.ID)
-	mbJSON, err := retrieveGetRequestData(&http.Client{}, musicbrainzSearch)
+	musicbrainzSearch := mb.Options.url(musicBrainzURL, fmt.Sprintf(musicBrainzReleaseURL, mb.ID))
+	mbJSON, err := musicBrainzGet(ctx, mb.Options, musicbrainzSearch)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal([]byte(mbJSON), &mb.Info)
+	return json.Unmarshal(mbJSON, &mb.Info)
+}
+
+// TrackCount of the release, across all media.
+func (r *MusicBrainzReleaseResults) TrackCount() int {
+	count := 0
+	for _, m := range r.Media {
+		count += len(m.Tracks)
+	}
+	return count
+}
+
+// Track at a given position of a medium, or nil.
+func (r *MusicBrainzReleaseResults) Track(medium, position int) *MusicBrainzTrack {
+	for i := range r.Media {
+		if r.Media[i].Position != medium {
+			continue
+		}
+		for j := range r.Media[i].Tracks {
+			if r.Media[i].Tracks[j].Position == position {
+				return &r.Media[i].Tracks[j]
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,168 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	musicBrainzSearchURL   = "/release"
+	musicBrainzSearchLimit = 25
+)
+
+// MusicBrainzReleaseCandidate is a release returned by a MusicBrainz search, with its relevance score.
+type MusicBrainzReleaseCandidate struct {
+	ArtistCredit   MusicBrainzArtistCredit `json:"artist-credit"`
+	Barcode        string                  `json:"barcode"`
+	Country        string                  `json:"country"`
+	Date           string                  `json:"date"`
+	Disambiguation string                  `json:"disambiguation"`
+	ID             string                  `json:"id"`
+	LabelInfo      []struct {
+		CatalogNumber string `json:"catalog-number"`
+		Label         struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"label"`
+	} `json:"label-info"`
+	Media []struct {
+		DiscCount  int    `json:"disc-count"`
+		Format     string `json:"format"`
+		TrackCount int    `json:"track-count"`
+	} `json:"media"`
+	ReleaseGroup struct {
+		ID          string `json:"id"`
+		PrimaryType string `json:"primary-type"`
+		Title       string `json:"title"`
+	} `json:"release-group"`
+	Score      int    `json:"score"`
+	Status     string `json:"status"`
+	Title      string `json:"title"`
+	TrackCount int    `json:"track-count"`
+}
+
+// MusicBrainzSearchResults is a struct describing the JSON response for a MusicBrainz release search.
+type MusicBrainzSearchResults struct {
+	Count    int                           `json:"count"`
+	Created  string                        `json:"created"`
+	Offset   int                           `json:"offset"`
+	Releases []MusicBrainzReleaseCandidate `json:"releases"`
+}
+
+// MusicBrainzQuery describes what is known about a release.
+// Empty fields are not part of the query.
+type MusicBrainzQuery struct {
+	Artist        string
+	Release       string
+	Barcode       string
+	CatalogNumber string
+	Label         string
+	Country       string
+	Date          string
+	Tracks        int
+}
+
+// luceneQuote a phrase, escaping what needs to be.
+func luceneQuote(phrase string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(phrase) + `"`
+}
+
+// String representation of the query, in Lucene syntax.
+func (q MusicBrainzQuery) String() string {
+	terms := []string{}
+	add := func(field, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			terms = append(terms, field+":"+luceneQuote(value))
+		}
+	}
+	add("artist", q.Artist)
+	add("release", q.Release)
+	add("barcode", q.Barcode)
+	add("catno", q.CatalogNumber)
+	add("label", q.Label)
+	add("country", q.Country)
+	add("date", q.Date)
+	if q.Tracks != 0 {
+		terms = append(terms, "tracks:"+strconv.Itoa(q.Tracks))
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// MusicBrainzSearch finds releases on MusicBrainz.
+type MusicBrainzSearch struct {
+	Query   MusicBrainzQuery
+	Limit   int
+	Offset  int
+	Options ProviderOptions
+	Results MusicBrainzSearchResults
+}
+
+// NewMusicBrainzSearch set up with a query.
+func NewMusicBrainzSearch(q MusicBrainzQuery) *MusicBrainzSearch {
+	return &MusicBrainzSearch{Query: q, Limit: musicBrainzSearchLimit, Options: MusicBrainzOptions}
+}
+
+// URL of the search request.
+func (s *MusicBrainzSearch) URL() (string, error) {
+	query := s.Query.String()
+	if query == "" {
+		return "", errors.New("Empty MusicBrainz query")
+	}
+	searchURL, err := url.Parse(s.Options.url(musicBrainzURL, musicBrainzSearchURL))
+	if err != nil {
+		return "", err
+	}
+	q := searchURL.Query()
+	q.Set("query", query)
+	q.Set("fmt", "json")
+	if s.Limit != 0 {
+		q.Set("limit", strconv.Itoa(s.Limit))
+	}
+	if s.Offset != 0 {
+		q.Set("offset", strconv.Itoa(s.Offset))
+	}
+	searchURL.RawQuery = q.Encode()
+	return searchURL.String(), nil
+}
+
+// Search MusicBrainz for matching releases.
+func (s *MusicBrainzSearch) Search() error {
+	return s.SearchContext(context.Background())
+}
+
+// SearchContext MusicBrainz for matching releases, until the context is done.
+func (s *MusicBrainzSearch) SearchContext(ctx context.Context) error {
+	searchURL, err := s.URL()
+	if err != nil {
+		return err
+	}
+	mbJSON, err := musicBrainzGet(ctx, s.Options, searchURL)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(mbJSON, &s.Results); err != nil {
+		return fmt.Errorf("Could not read JSON data from MusicBrainz: %s", err.Error())
+	}
+	return nil
+}
+
+// Candidates with at least a given score, best first.
+func (s *MusicBrainzSearch) Candidates(minScore int) []MusicBrainzReleaseCandidate {
+	candidates := []MusicBrainzReleaseCandidate{}
+	for _, r := range s.Results.Releases {
+		if r.Score >= minScore {
+			candidates = append(candidates, r)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
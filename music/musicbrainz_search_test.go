@@ -0,0 +1,93 @@
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMusicBrainzSearchJSON = `{
+  "created": "2017-01-29T10:47:13.391Z",
+  "count": 3,
+  "offset": 0,
+  "releases": [
+    {"id": "b1", "score": 62, "title": "Kid A", "status": "Bootleg", "country": "XE", "date": "2000",
+     "artist-credit": [{"name": "Radiohead", "artist": {"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Radiohead"}}],
+     "track-count": 10, "media": [{"format": "CD", "disc-count": 0, "track-count": 10}]},
+    {"id": "a3b0e5eb-fa3b-3e4d-b5e6-d0881984a183", "score": 100, "title": "Kid A", "status": "Official",
+     "country": "GB", "date": "2000-10-02", "barcode": "724352775324",
+     "artist-credit": [{"name": "Radiohead", "artist": {"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Radiohead"}}],
+     "release-group": {"id": "b8048f24-c026-3398-b23a-b5e50716cbc7", "primary-type": "Album", "title": "Kid A"},
+     "label-info": [{"catalog-number": "527 7532", "label": {"id": "df7d1c7f-ef95-425f-8eef-445b3d7bcbd9", "name": "Parlophone"}}],
+     "track-count": 10, "media": [{"format": "CD", "disc-count": 3, "track-count": 10}]},
+    {"id": "b3", "score": 90, "title": "Kid A", "status": "Official", "country": "US", "date": "2000-10-03",
+     "artist-credit": [{"name": "Radiohead", "artist": {"id": "a74b1b7f-71a5-4011-9441-d0b5e4122711", "name": "Radiohead"}}],
+     "track-count": 10}
+  ]
+}`
+
+func TestMusicBrainzSearchQuery(t *testing.T) {
+	fmt.Println("+ Testing MusicBrainz search queries...")
+	check := assert.New(t)
+
+	q := MusicBrainzQuery{Artist: "Radiohead", Release: "Kid A", Tracks: 10}
+	check.Equal(`artist:"Radiohead" AND release:"Kid A" AND tracks:10`, q.String())
+	q = MusicBrainzQuery{Artist: `The "Band"`, CatalogNumber: "527 7532", Label: "Parlophone", Country: "GB", Date: "2000", Barcode: " 724352775324 "}
+	check.Equal(`artist:"The \"Band\"" AND barcode:"724352775324" AND catno:"527 7532" AND label:"Parlophone" AND country:"GB" AND date:"2000"`, q.String())
+
+	s := NewMusicBrainzSearch(MusicBrainzQuery{})
+	_, err := s.URL()
+	check.NotNil(err, "Empty queries should be rejected")
+	check.NotNil(s.Search())
+
+	s = NewMusicBrainzSearch(MusicBrainzQuery{Artist: "Radiohead", Release: "Kid A"})
+	s.Offset = 25
+	searchURL, err := s.URL()
+	require.Nil(t, err)
+	u, err := url.Parse(searchURL)
+	require.Nil(t, err)
+	check.Equal("/ws/2/release", u.Path)
+	check.Equal(`artist:"Radiohead" AND release:"Kid A"`, u.Query().Get("query"))
+	check.Equal("json", u.Query().Get("fmt"))
+	check.Equal("25", u.Query().Get("limit"))
+	check.Equal("25", u.Query().Get("offset"))
+
+	require.Nil(t, json.Unmarshal([]byte(testMusicBrainzSearchJSON), &s.Results))
+	check.Equal(3, s.Results.Count)
+	candidates := s.Candidates(0)
+	require.Equal(t, 3, len(candidates))
+	check.Equal("a3b0e5eb-fa3b-3e4d-b5e6-d0881984a183", candidates[0].ID)
+	check.Equal("527 7532", candidates[0].LabelInfo[0].CatalogNumber)
+	check.Equal("Parlophone", candidates[0].LabelInfo[0].Label.Name)
+	check.Equal("Radiohead", candidates[0].ArtistCredit.String())
+	check.Equal("b3", candidates[1].ID)
+	check.Equal("b1", candidates[2].ID)
+	check.Equal(2, len(s.Candidates(90)))
+}
+
+func TestMusicBrainzSearch(t *testing.T) {
+	fmt.Println("+ Testing MusicBrainz search...")
+	check := assert.New(t)
+	options := testFixtureOptions(t)
+
+	for _, t := range testMBReleases {
+		fmt.Println("Testing with " + t.artist + " - " + t.albumTitle)
+		s := NewMusicBrainzSearch(MusicBrainzQuery{Artist: t.artist, Release: t.albumTitle, CatalogNumber: t.expectedCatalogNumber})
+		s.Options = options
+		err := s.Search()
+		check.Nil(err, "Unexpected error searching MusicBrainz")
+
+		found := false
+		for _, c := range s.Candidates(50) {
+			if c.ID == t.mbReleaseID {
+				found = true
+				break
+			}
+		}
+		check.True(found, "Release was not found on MusicBrainz")
+	}
+}
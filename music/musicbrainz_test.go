@@ -1,12 +1,15 @@
 package music
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	"strings"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Creative Commons tracks, see test/source.md
@@ -36,10 +39,12 @@ var testMBReleases = []struct {
 func TestMusicBrainz(t *testing.T) {
 	fmt.Println("+ Testing MusicBrainz...")
 	check := assert.New(t)
+	options := testFixtureOptions(t)
 
 	for _, t := range testTracks {
 		fmt.Println("Testing with " + t.path)
 		a := NewMusicBrainzRelease(t.mbReleaseID)
+		a.Options = options
 
 		err := a.GetInfo()
 		check.Nil(err, "Unexpected error getting MusicBrainz info")
@@ -47,11 +52,17 @@ func TestMusicBrainz(t *testing.T) {
 		check.Equal(t.mbReleaseID, a.Info.ID)
 		check.Equal(t.albumTitle, a.Info.Title)
 		check.Equal(0, len(a.Info.LabelInfo)) // no label info, CC material
+		track := a.Info.Track(1, t.position)
+		check.NotNil(track, "Track should be in the tracklist")
+		if track != nil {
+			check.Equal(t.title, track.Title)
+		}
 	}
 
 	for _, t := range testMBReleases {
 		fmt.Println("Testing with " + t.mbReleaseID)
 		a := NewMusicBrainzRelease(t.mbReleaseID)
+		a.Options = options
 
 		err := a.GetInfo()
 		check.Nil(err, "Unexpected error getting MusicBrainz info")
@@ -66,3 +77,78 @@ func TestMusicBrainz(t *testing.T) {
 	}
 
 }
+
+// trimmed response for a two-disc release, with inc=recordings+media+release-groups+isrcs
+const testMusicBrainzReleaseJSON = `{
+  "id": "b84ee12a-09ef-421b-82de-0441a926375b",
+  "title": "The Fragile",
+  "date": "1999-09-21",
+  "country": "US",
+  "barcode": "606949049020",
+  "artist-credit": [{"name": "Nine Inch Nails", "joinphrase": "", "artist": {"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails", "sort-name": "Nine Inch Nails"}}],
+  "release-group": {
+    "id": "7cc48eab-6ff5-37c9-9a7f-39f4abe7ffb5",
+    "title": "The Fragile",
+    "primary-type": "Album",
+    "secondary-types": [],
+    "first-release-date": "1999-09-21"
+  },
+  "media": [
+    {
+      "format": "CD", "position": 1, "title": "Left", "track-count": 2, "track-offset": 0,
+      "tracks": [
+        {"id": "t1", "number": "1", "position": 1, "title": "Somewhat Damaged", "length": 271000,
+         "recording": {"id": "r1", "title": "Somewhat Damaged", "length": 271000, "isrcs": ["USIR19915501"]}},
+        {"id": "t2", "number": "2", "position": 2, "title": "The Day the World Went Away", "length": null,
+         "recording": {"id": "r2", "title": "The Day the World Went Away", "length": 273000, "isrcs": []}}
+      ]
+    },
+    {
+      "format": "CD", "position": 2, "title": "Right", "track-count": 1, "track-offset": 0,
+      "tracks": [
+        {"id": "t3", "number": "1", "position": 1, "title": "Please", "length": 210000,
+         "artist-credit": [
+           {"name": "Nine Inch Nails", "joinphrase": " feat. ", "artist": {"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails"}},
+           {"name": "Someone Else", "joinphrase": "", "artist": {"id": "x", "name": "Someone Else"}}
+         ],
+         "recording": {"id": "r3", "title": "Please", "length": 210000}}
+      ]
+    }
+  ]
+}`
+
+func TestMusicBrainzTracklist(t *testing.T) {
+	fmt.Println("+ Testing MusicBrainz tracklist...")
+	check := assert.New(t)
+
+	mb := NewMusicBrainzRelease("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, json.Unmarshal([]byte(testMusicBrainzReleaseJSON), &mb.Info))
+
+	check.Equal("Nine Inch Nails", mb.Info.ArtistCredit.String())
+	check.Equal("Album", mb.Info.ReleaseGroup.PrimaryType)
+	check.Equal("1999-09-21", mb.Info.ReleaseGroup.FirstReleaseDate)
+	require.Equal(t, 2, len(mb.Info.Media))
+	check.Equal("Left", mb.Info.Media[0].Title)
+	check.Equal("CD", mb.Info.Media[1].Format)
+	check.Equal(3, mb.Info.TrackCount())
+
+	track := mb.Info.Track(1, 1)
+	require.NotNil(t, track)
+	check.Equal("Somewhat Damaged", track.Title)
+	check.Equal("r1", track.Recording.ID)
+	check.Equal([]string{"USIR19915501"}, track.Recording.Isrcs)
+	check.Equal(271*time.Second, track.Duration())
+
+	// null track length, falling back to the recording
+	track = mb.Info.Track(1, 2)
+	require.NotNil(t, track)
+	check.Equal(273*time.Second, track.Duration())
+
+	track = mb.Info.Track(2, 1)
+	require.NotNil(t, track)
+	check.Equal("Please", track.Title)
+	check.Equal("Nine Inch Nails feat. Someone Else", track.ArtistCredit.String())
+
+	check.Nil(mb.Info.Track(2, 2))
+	check.Nil(mb.Info.Track(3, 1))
+}
@@ -0,0 +1,400 @@
+package music
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProviderTimeout = 30 * time.Second
+
+var (
+	// ErrNotSupported is returned by providers for operations they cannot perform.
+	ErrNotSupported = errors.New("Operation not supported by this provider")
+	// UserAgent identifies the application to providers whose options do not set one.
+	UserAgent = "aubergine/0.1 ( https://github.com/barsanuphe/aubergine )"
+)
+
+// ProviderOptions configure how a provider reaches its web service,
+// to use a local mirror, a proxy, a cache, or a test server.
+// Zero values use the provider defaults.
+type ProviderOptions struct {
+	BaseURL string
+	// Client used with its own transport and timeout if set.
+	// Otherwise a client is set up with Transport and Timeout.
+	Client    *http.Client
+	Transport http.RoundTripper
+	Timeout   time.Duration // 30s if 0
+	UserAgent string
+	// Cache of the responses, if set, fresh for CacheTTL (24h if 0).
+	Cache    HTTPCache
+	CacheTTL time.Duration
+}
+
+// httpClient for the requests of a provider, waiting for a rate limiter if not nil.
+// Cached responses do not wait for the rate limiter.
+func (o ProviderOptions) httpClient(limiter *RateLimiter) *http.Client {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = defaultProviderTimeout
+	}
+	client := &http.Client{Transport: o.Transport, Timeout: timeout}
+	if o.Client != nil {
+		c := *o.Client
+		client = &c
+	}
+	if limiter == nil && o.Cache == nil {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if limiter != nil {
+		transport = &rateLimitedTransport{limiter: limiter, transport: transport}
+	}
+	if o.Cache != nil {
+		transport = &cacheTransport{cache: o.Cache, ttl: o.CacheTTL, transport: transport}
+	}
+	client.Transport = transport
+	return client
+}
+
+// url of an endpoint, relative to the base URL or its default.
+func (o ProviderOptions) url(defaultBaseURL, endpoint string) string {
+	baseURL := o.BaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return strings.TrimSuffix(baseURL, "/") + endpoint
+}
+
+// userAgent of the requests, or its default.
+func (o ProviderOptions) userAgent(defaultUserAgent string) string {
+	if o.UserAgent != "" {
+		return o.UserAgent
+	}
+	return defaultUserAgent
+}
+
+// ReleaseQuery describes what is known about a release, for a search.
+// Empty fields are ignored.
+type ReleaseQuery struct {
+	Artist        string
+	Title         string
+	Barcode       string
+	CatalogNumber string
+	Label         string
+	Country       string
+	Year          int
+	Tracks        int
+}
+
+// MetadataProvider is a source of release information.
+// Operations a provider cannot perform return ErrNotSupported.
+type MetadataProvider interface {
+	// Name of the provider, unique in the registry.
+	Name() string
+	// SearchReleases matching a query.
+	SearchReleases(q ReleaseQuery) ([]*Release, error)
+	// ReleaseByID with its full tracklist.
+	ReleaseByID(id string) (*Release, error)
+	// ReleasesByFingerprint of a track, with its duration in seconds.
+	ReleasesByFingerprint(fingerprint string, duration int) ([]*Release, error)
+}
+
+// ContextProvider is a MetadataProvider whose operations can be cancelled.
+type ContextProvider interface {
+	MetadataProvider
+	SearchReleasesContext(ctx context.Context, q ReleaseQuery) ([]*Release, error)
+	ReleaseByIDContext(ctx context.Context, id string) (*Release, error)
+	ReleasesByFingerprintContext(ctx context.Context, fingerprint string, duration int) ([]*Release, error)
+}
+
+var (
+	providers      = map[string]MetadataProvider{}
+	providersMutex sync.RWMutex
+)
+
+// RegisterProvider so that it can be used by name, and by SearchAllProviders.
+func RegisterProvider(p MetadataProvider) error {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	if _, ok := providers[p.Name()]; ok {
+		return fmt.Errorf("Provider %s is already registered", p.Name())
+	}
+	providers[p.Name()] = p
+	return nil
+}
+
+// UnregisterProvider by name.
+func UnregisterProvider(name string) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	delete(providers, name)
+}
+
+// Provider registered under a given name.
+func Provider(name string) (MetadataProvider, error) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown provider %s", name)
+	}
+	return p, nil
+}
+
+// Providers registered, sorted by name.
+func Providers() []MetadataProvider {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+	list := []MetadataProvider{}
+	for _, p := range providers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// SearchAllProviders for releases, ignoring providers that do not support searching.
+// Results from all providers are returned even if some of them failed.
+func SearchAllProviders(q ReleaseQuery) ([]*Release, error) {
+	return SearchAllProvidersContext(context.Background(), q)
+}
+
+// SearchAllProvidersContext for releases, until the context is done.
+// Providers that are not ContextProviders are only skipped once it is done.
+func SearchAllProvidersContext(ctx context.Context, q ReleaseQuery) ([]*Release, error) {
+	return allProviders(ctx, func(p MetadataProvider) ([]*Release, error) {
+		if cp, ok := p.(ContextProvider); ok {
+			return cp.SearchReleasesContext(ctx, q)
+		}
+		return p.SearchReleases(q)
+	})
+}
+
+// LookUpFingerprintAllProviders for releases, ignoring providers that do not support fingerprints.
+func LookUpFingerprintAllProviders(fingerprint string, duration int) ([]*Release, error) {
+	return LookUpFingerprintAllProvidersContext(context.Background(), fingerprint, duration)
+}
+
+// LookUpFingerprintAllProvidersContext for releases, until the context is done.
+func LookUpFingerprintAllProvidersContext(ctx context.Context, fingerprint string, duration int) ([]*Release, error) {
+	return allProviders(ctx, func(p MetadataProvider) ([]*Release, error) {
+		if cp, ok := p.(ContextProvider); ok {
+			return cp.ReleasesByFingerprintContext(ctx, fingerprint, duration)
+		}
+		return p.ReleasesByFingerprint(fingerprint, duration)
+	})
+}
+
+// allProviders collects the releases found by all providers.
+// Errors are only returned, all of them, if no provider succeeded.
+func allProviders(ctx context.Context, do func(p MetadataProvider) ([]*Release, error)) ([]*Release, error) {
+	releases := []*Release{}
+	errs := []string{}
+	succeeded := false
+	for _, p := range Providers() {
+		if err := ctx.Err(); err != nil {
+			return releases, err
+		}
+		found, err := do(p)
+		if err == ErrNotSupported {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", p.Name(), err.Error()))
+			continue
+		}
+		succeeded = true
+		releases = append(releases, found...)
+	}
+	if succeeded || len(errs) == 0 {
+		return releases, nil
+	}
+	return releases, errors.New(strings.Join(errs, "; "))
+}
+
+//------------------------
+
+// Name of the MusicBrainz provider.
+func (mb *MusicBrainzRelease) Name() string {
+	return MusicBrainzProvider
+}
+
+// SearchReleases on MusicBrainz.
+func (mb *MusicBrainzRelease) SearchReleases(q ReleaseQuery) ([]*Release, error) {
+	return mb.SearchReleasesContext(context.Background(), q)
+}
+
+// SearchReleasesContext on MusicBrainz, until the context is done.
+func (mb *MusicBrainzRelease) SearchReleasesContext(ctx context.Context, q ReleaseQuery) ([]*Release, error) {
+	mbq := MusicBrainzQuery{
+		Artist:        q.Artist,
+		Release:       q.Title,
+		Barcode:       q.Barcode,
+		CatalogNumber: q.CatalogNumber,
+		Label:         q.Label,
+		Country:       q.Country,
+		Tracks:        q.Tracks,
+	}
+	if q.Year != 0 {
+		mbq.Date = strconv.Itoa(q.Year)
+	}
+	s := NewMusicBrainzSearch(mbq)
+	s.Options = mb.Options
+	if err := s.SearchContext(ctx); err != nil {
+		return nil, err
+	}
+	releases := []*Release{}
+	for _, c := range s.Candidates(0) {
+		releases = append(releases, c.Release())
+	}
+	return releases, nil
+}
+
+// ReleaseByID on MusicBrainz.
+func (mb *MusicBrainzRelease) ReleaseByID(id string) (*Release, error) {
+	return mb.ReleaseByIDContext(context.Background(), id)
+}
+
+// ReleaseByIDContext on MusicBrainz, until the context is done.
+// The provider is not modified, so that it can be shared.
+func (mb *MusicBrainzRelease) ReleaseByIDContext(ctx context.Context, id string) (*Release, error) {
+	m := *mb
+	m.ID = id
+	if err := m.GetInfoContext(ctx); err != nil {
+		return nil, err
+	}
+	return m.Info.Release(), nil
+}
+
+// ReleasesByFingerprint is not supported by MusicBrainz.
+func (mb *MusicBrainzRelease) ReleasesByFingerprint(fingerprint string, duration int) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// ReleasesByFingerprintContext is not supported by MusicBrainz.
+func (mb *MusicBrainzRelease) ReleasesByFingerprintContext(ctx context.Context, fingerprint string, duration int) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+//------------------------
+
+// Name of the Discogs provider.
+func (d *DiscogsRelease) Name() string {
+	return DiscogsProvider
+}
+
+// SearchReleases on Discogs.
+func (d *DiscogsRelease) SearchReleases(q ReleaseQuery) ([]*Release, error) {
+	return d.SearchReleasesContext(context.Background(), q)
+}
+
+// SearchReleasesContext on Discogs, until the context is done.
+func (d *DiscogsRelease) SearchReleasesContext(ctx context.Context, q ReleaseQuery) ([]*Release, error) {
+	v := url.Values{}
+	v.Set("type", "release")
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("artist", q.Artist)
+	set("release_title", q.Title)
+	set("barcode", q.Barcode)
+	set("catno", q.CatalogNumber)
+	set("label", q.Label)
+	set("country", q.Country)
+	if q.Year != 0 {
+		v.Set("year", strconv.Itoa(q.Year))
+	}
+	// the provider is not modified, so that it can be shared
+	search := *d
+	if err := search.search(ctx, v); err != nil {
+		return nil, err
+	}
+	return search.Info.Releases(), nil
+}
+
+// ReleaseByID on Discogs.
+func (d *DiscogsRelease) ReleaseByID(id string) (*Release, error) {
+	return d.ReleaseByIDContext(context.Background(), id)
+}
+
+// ReleaseByIDContext on Discogs, until the context is done.
+func (d *DiscogsRelease) ReleaseByIDContext(ctx context.Context, id string) (*Release, error) {
+	discogsID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, errors.New("Invalid Discogs release ID: " + id)
+	}
+	// the provider is not modified, so that it can be shared
+	r := *d
+	if err := r.GetReleaseContext(ctx, discogsID); err != nil {
+		return nil, err
+	}
+	return r.Details.Release(), nil
+}
+
+// ReleasesByFingerprint is not supported by Discogs.
+func (d *DiscogsRelease) ReleasesByFingerprint(fingerprint string, duration int) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// ReleasesByFingerprintContext is not supported by Discogs.
+func (d *DiscogsRelease) ReleasesByFingerprintContext(ctx context.Context, fingerprint string, duration int) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+//------------------------
+
+// Name of the AcoustID provider.
+func (a *AcousticID) Name() string {
+	return AcoustIDProvider
+}
+
+// SearchReleases is not supported by AcoustID.
+func (a *AcousticID) SearchReleases(q ReleaseQuery) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// SearchReleasesContext is not supported by AcoustID.
+func (a *AcousticID) SearchReleasesContext(ctx context.Context, q ReleaseQuery) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// ReleaseByID is not supported by AcoustID.
+func (a *AcousticID) ReleaseByID(id string) (*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// ReleaseByIDContext is not supported by AcoustID.
+func (a *AcousticID) ReleaseByIDContext(ctx context.Context, id string) (*Release, error) {
+	return nil, ErrNotSupported
+}
+
+// ReleasesByFingerprint on AcoustID.
+func (a *AcousticID) ReleasesByFingerprint(fingerprint string, duration int) ([]*Release, error) {
+	return a.ReleasesByFingerprintContext(context.Background(), fingerprint, duration)
+}
+
+// ReleasesByFingerprintContext on AcoustID, until the context is done.
+// The provider is not modified, so that it can be shared.
+func (a *AcousticID) ReleasesByFingerprintContext(ctx context.Context, fingerprint string, duration int) ([]*Release, error) {
+	lookup := *a
+	lookup.Fingerprint = fingerprint
+	lookup.Duration = strconv.Itoa(duration)
+	results, err := lookup.LookUpContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return results.Releases(), nil
+}
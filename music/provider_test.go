@@ -0,0 +1,222 @@
+package music
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Providers implemented in this package.
+var (
+	_ MetadataProvider = (*MusicBrainzRelease)(nil)
+	_ MetadataProvider = (*DiscogsRelease)(nil)
+	_ MetadataProvider = (*AcousticID)(nil)
+)
+
+// testCatalog is a local JSON catalog, as an example of a new source.
+type testCatalog struct {
+	name     string
+	releases []*Release
+	fail     bool
+}
+
+func (c *testCatalog) Name() string {
+	return c.name
+}
+
+func (c *testCatalog) SearchReleases(q ReleaseQuery) ([]*Release, error) {
+	if c.fail {
+		return nil, errors.New("catalog unavailable")
+	}
+	found := []*Release{}
+	for _, r := range c.releases {
+		if strings.EqualFold(r.Artists.String(), q.Artist) && strings.EqualFold(r.Title, q.Title) {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (c *testCatalog) ReleaseByID(id string) (*Release, error) {
+	for _, r := range c.releases {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (c *testCatalog) ReleasesByFingerprint(fingerprint string, duration int) ([]*Release, error) {
+	return nil, ErrNotSupported
+}
+
+func TestProviders(t *testing.T) {
+	fmt.Println("+ Testing metadata providers...")
+	check := assert.New(t)
+
+	info := MusicBrainzReleaseResults{}
+	require.Nil(t, json.Unmarshal([]byte(testMusicBrainzReleaseJSON), &info))
+	catalog := &testCatalog{name: "local", releases: []*Release{info.Release()}}
+	broken := &testCatalog{name: "broken", fail: true}
+
+	require.Nil(t, RegisterProvider(catalog))
+	defer UnregisterProvider(catalog.Name())
+	require.Nil(t, RegisterProvider(broken))
+	defer UnregisterProvider(broken.Name())
+	check.NotNil(RegisterProvider(catalog), "Providers can only be registered once")
+
+	p, err := Provider("local")
+	require.Nil(t, err)
+	check.Equal(catalog, p)
+	_, err = Provider("nope")
+	check.NotNil(err)
+	providers := Providers()
+	require.Equal(t, 2, len(providers))
+	check.Equal("broken", providers[0].Name())
+
+	r, err := p.ReleaseByID("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, err)
+	check.Equal(3, r.TrackCount())
+
+	// failing providers do not hide results from the others
+	releases, err := SearchAllProviders(ReleaseQuery{Artist: "nine inch nails", Title: "the fragile"})
+	check.Nil(err, "Errors are only returned if all providers failed")
+	require.Equal(t, 1, len(releases))
+	check.Equal("The Fragile", releases[0].Title)
+	other := &testCatalog{name: "other", fail: true}
+	require.Nil(t, RegisterProvider(other))
+	catalog.fail = true
+	_, err = SearchAllProviders(ReleaseQuery{Artist: "nine inch nails", Title: "the fragile"})
+	require.NotNil(t, err)
+	check.Equal("broken: catalog unavailable; local: catalog unavailable; other: catalog unavailable", err.Error())
+	catalog.fail = false
+	UnregisterProvider(other.Name())
+
+	// nothing is searched once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	releases, err = SearchAllProvidersContext(ctx, ReleaseQuery{Artist: "nine inch nails", Title: "the fragile"})
+	check.Equal(context.Canceled, err)
+	check.Equal(0, len(releases))
+
+	// unsupported operations are skipped
+	UnregisterProvider(broken.Name())
+	releases, err = LookUpFingerprintAllProviders("AQAD", 100)
+	check.Nil(err)
+	check.Equal(0, len(releases))
+
+	// built-in providers
+	mb := NewMusicBrainzRelease("")
+	check.Equal(MusicBrainzProvider, mb.Name())
+	_, err = mb.ReleasesByFingerprint("AQAD", 100)
+	check.Equal(ErrNotSupported, err)
+	d := NewDiscogsRelease("", "")
+	check.Equal(DiscogsProvider, d.Name())
+	_, err = d.ReleasesByFingerprint("AQAD", 100)
+	check.Equal(ErrNotSupported, err)
+	_, err = d.ReleaseByID("not a number")
+	check.NotNil(err)
+	a := NewAcoustid("")
+	check.Equal(AcoustIDProvider, a.Name())
+	_, err = a.SearchReleases(ReleaseQuery{Artist: "Radiohead"})
+	check.Equal(ErrNotSupported, err)
+	_, err = a.ReleaseByID("a3b0e5eb-fa3b-3e4d-b5e6-d0881984a183")
+	check.Equal(ErrNotSupported, err)
+	_, err = a.ReleasesByFingerprint("", 0)
+	check.NotNil(err, "Fingerprint is required")
+}
+
+// testTransport counts the requests going through it.
+type testTransport struct {
+	requests int
+}
+
+func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestProviderOptions(t *testing.T) {
+	fmt.Println("+ Testing provider options...")
+	check := assert.New(t)
+
+	defer MusicBrainzRateLimiter.SetRate(time.Second, 1)
+	MusicBrainzRateLimiter.SetRate(time.Millisecond, 1)
+
+	userAgents := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents[r.URL.Path] = r.Header.Get("User-Agent")
+		switch r.URL.Path {
+		case "/mirror/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b":
+			fmt.Fprint(w, testMusicBrainzReleaseJSON)
+		case "/mirror/ws/2/release":
+			check.Equal("json", r.URL.Query().Get("fmt"))
+			fmt.Fprint(w, testMusicBrainzSearchJSON)
+		case "/mirror/ws/2/release/slow":
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprint(w, testMusicBrainzReleaseJSON)
+		case "/releases/249504":
+			fmt.Fprint(w, testDiscogsReleaseJSON)
+		case "/v2/lookup":
+			fmt.Fprint(w, `{"status": "ok", "results": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// a local MusicBrainz mirror for all new releases and searches
+	defer func(o ProviderOptions) { MusicBrainzOptions = o }(MusicBrainzOptions)
+	MusicBrainzOptions = ProviderOptions{BaseURL: server.URL + "/mirror/ws/2/", UserAgent: "mirror-test/1.0"}
+	mb := NewMusicBrainzRelease("")
+	r, err := mb.ReleaseByID("b84ee12a-09ef-421b-82de-0441a926375b")
+	require.Nil(t, err)
+	check.Equal("The Fragile", r.Title)
+	check.Equal("mirror-test/1.0", userAgents["/mirror/ws/2/release/b84ee12a-09ef-421b-82de-0441a926375b"])
+	releases, err := mb.SearchReleases(ReleaseQuery{Artist: "Radiohead", Title: "Kid A"})
+	require.Nil(t, err)
+	check.Equal(3, len(releases))
+	check.Equal("mirror-test/1.0", userAgents["/mirror/ws/2/release"])
+
+	mb = NewMusicBrainzRelease("slow")
+	mb.Options.Timeout = 20 * time.Millisecond
+	check.NotNil(mb.GetInfo(), "Timeout")
+	mb.Options.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	check.NotNil(mb.GetInfoContext(ctx), "Deadline")
+	check.True(time.Since(start) < 150*time.Millisecond)
+
+	// an injected client, for Discogs
+	transport := &testTransport{}
+	d := NewDiscogsRelease("token", "secret")
+	d.Options = ProviderOptions{BaseURL: server.URL, Client: &http.Client{Transport: transport}}
+	r, err = d.ReleaseByID("249504")
+	require.Nil(t, err)
+	check.Equal("Never Gonna Give You Up", r.Title)
+	check.Equal(1, transport.requests)
+	check.Equal(UserAgent, userAgents["/releases/249504"])
+
+	// a transport, for AcoustID
+	a := NewAcoustid("key")
+	a.Options = ProviderOptions{BaseURL: server.URL + "/v2", Transport: transport}
+	releases, err = a.ReleasesByFingerprint("AQAD", 100)
+	require.Nil(t, err)
+	check.Equal(0, len(releases))
+	check.Equal(2, transport.requests)
+
+	// defaults
+	check.Equal(musicBrainzURL+"/release", ProviderOptions{}.url(musicBrainzURL, "/release"))
+	check.Equal(defaultProviderTimeout, ProviderOptions{}.httpClient(nil).Timeout)
+	check.Equal(MusicBrainzUserAgent, ProviderOptions{}.userAgent(MusicBrainzUserAgent))
+}
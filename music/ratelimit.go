@@ -0,0 +1,125 @@
+package music
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket: requests can be made in bursts, and one
+// token is regained every interval.
+type RateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+// NewRateLimiter allowing one request every interval, in bursts of up to burst requests.
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{interval: interval, burst: burst, tokens: float64(burst)}
+}
+
+// SetRate of the limiter, keeping the tokens already available.
+func (r *RateLimiter) SetRate(interval time.Duration, burst int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	if burst < 1 {
+		burst = 1
+	}
+	r.interval = interval
+	r.burst = burst
+	if r.tokens > float64(burst) {
+		r.tokens = float64(burst)
+	}
+}
+
+func (r *RateLimiter) refill(now time.Time) {
+	if !r.last.IsZero() && r.interval > 0 {
+		r.tokens += float64(now.Sub(r.last)) / float64(r.interval)
+	} else if r.interval <= 0 {
+		r.tokens = float64(r.burst)
+	}
+	if r.tokens > float64(r.burst) {
+		r.tokens = float64(r.burst)
+	}
+	r.last = now
+}
+
+// reserve a token, returning how long to wait before using it.
+func (r *RateLimiter) reserve() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.refill(time.Now())
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens * float64(r.interval))
+}
+
+// Wait until a request can be made.
+func (r *RateLimiter) Wait() {
+	r.WaitContext(context.Background())
+}
+
+// WaitContext until a request can be made, or the context is done.
+// The request is not counted if the context is done first.
+func (r *RateLimiter) WaitContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := sleepContext(ctx, r.reserve()); err != nil {
+		r.mutex.Lock()
+		r.tokens++
+		r.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// sleepContext for a while, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryDelay before trying again after a 503 or 429 response, from its
+// Retry-After header (in seconds or as a date), or the fallback.
+func retryDelay(resp *http.Response, fallback time.Duration) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return fallback
+}
+
+// shouldRetry after this response.
+func shouldRetry(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
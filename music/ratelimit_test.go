@@ -0,0 +1,120 @@
+package music
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	fmt.Println("+ Testing rate limiter...")
+	check := assert.New(t)
+
+	r := NewRateLimiter(50*time.Millisecond, 2)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		r.Wait()
+	}
+	// 2 immediate requests, then one every 50ms
+	elapsed := time.Since(start)
+	check.True(elapsed >= 140*time.Millisecond, "Rate limiter too permissive: %s", elapsed)
+	check.True(elapsed < 400*time.Millisecond, "Rate limiter too strict: %s", elapsed)
+
+	r.SetRate(0, 1)
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		r.Wait()
+	}
+	check.True(time.Since(start) < 50*time.Millisecond, "Rate limiter should be disabled")
+
+	// cancelled requests do not count
+	r.SetRate(time.Hour, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	check.Nil(r.WaitContext(ctx))
+	check.Equal(context.DeadlineExceeded, r.WaitContext(ctx))
+	check.Equal(context.DeadlineExceeded, r.WaitContext(ctx))
+	check.InDelta(0, r.tokens, 0.01, "Tokens should be given back")
+}
+
+func TestRetryDelay(t *testing.T) {
+	fmt.Println("+ Testing Retry-After...")
+	check := assert.New(t)
+
+	resp := &http.Response{Header: http.Header{}}
+	check.Equal(3*time.Second, retryDelay(resp, 3*time.Second))
+	resp.Header.Set("Retry-After", "2")
+	check.Equal(2*time.Second, retryDelay(resp, 3*time.Second))
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	check.Equal(time.Duration(0), retryDelay(resp, 3*time.Second))
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	check.True(retryDelay(resp, 3*time.Second) > 50*time.Minute)
+	resp.Header.Set("Retry-After", "soon")
+	check.Equal(3*time.Second, retryDelay(resp, 3*time.Second))
+}
+
+func TestMusicBrainzRetries(t *testing.T) {
+	fmt.Println("+ Testing MusicBrainz retries...")
+	check := assert.New(t)
+
+	defer func(interval time.Duration) {
+		MusicBrainzRateLimiter.SetRate(interval, 1)
+		musicBrainzRetryDelay = interval
+	}(time.Second)
+	MusicBrainzRateLimiter.SetRate(10*time.Millisecond, 1)
+	musicBrainzRetryDelay = time.Millisecond
+
+	requests := 0
+	userAgents := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		switch {
+		case r.URL.Path == "/busy" && requests < 3:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/busy":
+			w.Write([]byte(`{"id": "ok"}`))
+		case r.URL.Path == "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	data, err := musicBrainzGet(context.Background(), MusicBrainzOptions, server.URL+"/busy")
+	require.Nil(t, err)
+	check.Equal(`{"id": "ok"}`, string(data))
+	check.Equal(3, requests)
+	for _, ua := range userAgents {
+		check.Equal(MusicBrainzUserAgent, ua)
+	}
+
+	requests = 0
+	_, err = musicBrainzGet(context.Background(), MusicBrainzOptions, server.URL+"/down")
+	check.NotNil(err)
+	check.Equal(MusicBrainzMaxRetries+1, requests)
+
+	requests = 0
+	_, err = musicBrainzGet(context.Background(), MusicBrainzOptions, server.URL+"/missing")
+	check.NotNil(err)
+	check.Equal(1, requests, "Only 503 and 429 should be retried")
+
+	// no more retries once the context is done
+	musicBrainzRetryDelay = time.Hour
+	requests = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = musicBrainzGet(ctx, MusicBrainzOptions, server.URL+"/down")
+	check.Equal(context.DeadlineExceeded, err)
+	check.Equal(1, requests)
+	check.True(time.Since(start) < time.Second)
+}
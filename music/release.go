@@ -0,0 +1,463 @@
+package music
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata providers.
+const (
+	MusicBrainzProvider = "musicbrainz"
+	DiscogsProvider     = "discogs"
+	AcoustIDProvider    = "acoustid"
+)
+
+// ArtistCredit is an artist credited on a release or a track.
+type ArtistCredit struct {
+	ID         string
+	Name       string
+	SortName   string
+	JoinPhrase string
+}
+
+// ArtistCredits is a list of artists credited together.
+type ArtistCredits []ArtistCredit
+
+// String representation of the credit, as it should be displayed.
+func (ac ArtistCredits) String() string {
+	credit := ""
+	for _, a := range ac {
+		credit += a.Name + a.JoinPhrase
+	}
+	return credit
+}
+
+// LabelInfo is a label and the catalog number it used for a release.
+type LabelInfo struct {
+	ID            string
+	Name          string
+	CatalogNumber string
+}
+
+// Track of a release medium.
+type Track struct {
+	ID          string
+	RecordingID string
+	Number      string // as printed on the release: "3", "A2"...
+	Position    int    // 1-based position on the medium
+	Title       string
+	Length      time.Duration
+	Artists     ArtistCredits
+	ISRCs       []string
+}
+
+// Medium is a disc of a release.
+type Medium struct {
+	Position int
+	Format   string
+	Title    string
+	// TrackCount of the medium, even when Tracks are unknown or incomplete
+	// (search hits and lookups), 0 if unknown.
+	TrackCount int
+	Tracks     []Track
+}
+
+// Count of the tracks of the medium, from its tracks or its TrackCount.
+func (m *Medium) Count() int {
+	if len(m.Tracks) > m.TrackCount {
+		return len(m.Tracks)
+	}
+	return m.TrackCount
+}
+
+// Release is the provider-neutral description of a release.
+type Release struct {
+	Provider       string
+	ID             string
+	ReleaseGroupID string // MusicBrainz release group, or Discogs master
+	Title          string
+	Artists        ArtistCredits
+	Date           string
+	Year           int
+	Country        string
+	Barcode        string
+	Status         string
+	Labels         []LabelInfo
+	Media          []Medium
+	Score          float64 // relevance, when the release comes from a search or lookup
+}
+
+// TrackCount across all media, even if their tracks are unknown.
+func (r *Release) TrackCount() int {
+	count := 0
+	for i := range r.Media {
+		count += r.Media[i].Count()
+	}
+	return count
+}
+
+// Tracks across all media, in order.
+func (r *Release) Tracks() []Track {
+	tracks := []Track{}
+	for _, m := range r.Media {
+		tracks = append(tracks, m.Tracks...)
+	}
+	return tracks
+}
+
+// Track at a given position of a medium, or nil.
+func (r *Release) Track(medium, position int) *Track {
+	for i := range r.Media {
+		if r.Media[i].Position != medium {
+			continue
+		}
+		for j := range r.Media[i].Tracks {
+			if r.Media[i].Tracks[j].Position == position {
+				return &r.Media[i].Tracks[j]
+			}
+		}
+	}
+	return nil
+}
+
+// Length of the release.
+func (r *Release) Length() time.Duration {
+	length := time.Duration(0)
+	for _, t := range r.Tracks() {
+		length += t.Length
+	}
+	return length
+}
+
+// String representation of the release.
+func (r *Release) String() string {
+	return fmt.Sprintf("%s - %s (%d)", r.Artists, r.Title, r.Year)
+}
+
+// parseYear from a date, which can be "2000", "2000-10" or "2000-10-02".
+func parseYear(date string) int {
+	if len(date) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(date[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+//------------------------
+
+func musicBrainzArtists(credit MusicBrainzArtistCredit) ArtistCredits {
+	artists := ArtistCredits{}
+	for _, a := range credit {
+		artists = append(artists, ArtistCredit{ID: a.Artist.ID, Name: a.Name, SortName: a.Artist.SortName, JoinPhrase: a.Joinphrase})
+	}
+	return artists
+}
+
+// Release from MusicBrainz results.
+func (r *MusicBrainzReleaseResults) Release() *Release {
+	release := &Release{
+		Provider:       MusicBrainzProvider,
+		ID:             r.ID,
+		ReleaseGroupID: r.ReleaseGroup.ID,
+		Title:          r.Title,
+		Artists:        musicBrainzArtists(r.ArtistCredit),
+		Date:           r.Date,
+		Year:           parseYear(r.Date),
+		Country:        r.Country,
+		Barcode:        r.Barcode,
+		Status:         r.Status,
+	}
+	for _, l := range r.LabelInfo {
+		release.Labels = append(release.Labels, LabelInfo{ID: l.Label.ID, Name: l.Label.Name, CatalogNumber: l.CatalogNumber})
+	}
+	for _, m := range r.Media {
+		medium := Medium{Position: m.Position, Format: m.Format, Title: m.Title, TrackCount: m.TrackCount}
+		for _, t := range m.Tracks {
+			track := Track{
+				ID:          t.ID,
+				RecordingID: t.Recording.ID,
+				Number:      t.Number,
+				Position:    t.Position,
+				Title:       t.Title,
+				Length:      t.Duration(),
+				Artists:     musicBrainzArtists(t.ArtistCredit),
+				ISRCs:       t.Recording.Isrcs,
+			}
+			// track artists are only given if they differ from the release artists
+			if len(track.Artists) == 0 {
+				track.Artists = release.Artists
+			}
+			medium.Tracks = append(medium.Tracks, track)
+		}
+		release.Media = append(release.Media, medium)
+	}
+	return release
+}
+
+// Release from a MusicBrainz search result, without tracklist.
+func (c MusicBrainzReleaseCandidate) Release() *Release {
+	release := &Release{
+		Provider:       MusicBrainzProvider,
+		ID:             c.ID,
+		ReleaseGroupID: c.ReleaseGroup.ID,
+		Title:          c.Title,
+		Artists:        musicBrainzArtists(c.ArtistCredit),
+		Date:           c.Date,
+		Year:           parseYear(c.Date),
+		Country:        c.Country,
+		Barcode:        c.Barcode,
+		Status:         c.Status,
+		Score:          float64(c.Score) / 100,
+	}
+	for _, l := range c.LabelInfo {
+		release.Labels = append(release.Labels, LabelInfo{ID: l.Label.ID, Name: l.Label.Name, CatalogNumber: l.CatalogNumber})
+	}
+	for i, m := range c.Media {
+		release.Media = append(release.Media, Medium{Position: i + 1, Format: m.Format, TrackCount: m.TrackCount})
+	}
+	if len(release.Media) == 0 && c.TrackCount != 0 {
+		release.Media = append(release.Media, Medium{Position: 1, TrackCount: c.TrackCount})
+	}
+	return release
+}
+
+//------------------------
+
+// discogsArtistNumber is appended by Discogs to tell homonyms apart.
+var discogsArtistNumber = regexp.MustCompile(`\s\(\d+\)$`)
+
+func discogsArtists(artists DiscogsArtists) ArtistCredits {
+	credits := ArtistCredits{}
+	for i, a := range artists {
+		credit := ArtistCredit{ID: strconv.Itoa(a.ID), Name: discogsArtistNumber.ReplaceAllString(a.CreditedName(), "")}
+		if i != len(artists)-1 {
+			switch a.Join {
+			case "", ",":
+				credit.JoinPhrase = a.Join + " "
+			default:
+				credit.JoinPhrase = " " + a.Join + " "
+			}
+		}
+		credits = append(credits, credit)
+	}
+	return credits
+}
+
+// discogsMedium guesses the medium of a track from its position.
+func discogsMedium(position string, vinylDiscs int) int {
+	p := parseDiscogsPosition(position, false)
+	switch {
+	case p.Disc > 0:
+		return p.Disc
+	case p.Side != "" && vinylDiscs > 1:
+		// two sides per disc
+		return int(p.Side[0]-'A')/2 + 1
+	}
+	return 1
+}
+
+// Release from Discogs release details.
+func (r *DiscogsReleaseResults) Release() *Release {
+	release := &Release{
+		Provider: DiscogsProvider,
+		ID:       strconv.Itoa(r.ID),
+		Title:    r.Title,
+		Artists:  discogsArtists(r.Artists),
+		Date:     r.Released,
+		Year:     r.Year,
+		Country:  r.Country,
+		Barcode:  r.Barcode(),
+		Status:   r.Status,
+	}
+	if r.MasterID != 0 {
+		release.ReleaseGroupID = strconv.Itoa(r.MasterID)
+	}
+	for _, l := range r.Labels {
+		release.Labels = append(release.Labels, LabelInfo{ID: strconv.Itoa(l.ID), Name: discogsArtistNumber.ReplaceAllString(l.Name, ""), CatalogNumber: l.Catno})
+	}
+	format, vinylDiscs := "", 0
+	if len(r.Formats) != 0 {
+		format = r.Formats[0].Name
+		if format == "Vinyl" {
+			vinylDiscs, _ = strconv.Atoi(r.Formats[0].Qty)
+		}
+	}
+	for _, t := range r.Tracks() {
+		mediumPosition := discogsMedium(t.Position, vinylDiscs)
+		for len(release.Media) < mediumPosition {
+			release.Media = append(release.Media, Medium{Position: len(release.Media) + 1, Format: format})
+		}
+		medium := &release.Media[mediumPosition-1]
+		track := Track{
+			Number:   t.Position,
+			Position: len(medium.Tracks) + 1,
+			Title:    t.Title,
+			Length:   t.Length(),
+			Artists:  discogsArtists(t.Artists),
+		}
+		if len(track.Artists) == 0 {
+			track.Artists = release.Artists
+		}
+		medium.Tracks = append(medium.Tracks, track)
+		medium.TrackCount = len(medium.Tracks)
+	}
+	return release
+}
+
+// Releases from Discogs search hits, without tracklists.
+// Search hits give the number of media but not their track counts.
+func (r *DiscogsResults) Releases() []*Release {
+	releases := []*Release{}
+	for i, hit := range r.Results {
+		release := &Release{
+			Provider: DiscogsProvider,
+			ID:       strconv.Itoa(hit.ID),
+			Title:    hit.Title,
+			Year:     parseYear(hit.Year),
+			Date:     hit.Year,
+			Country:  hit.Country,
+		}
+		if master := r.MasterID(i); master != 0 {
+			release.ReleaseGroupID = strconv.Itoa(master)
+		}
+		// search hits are titled "Artist - Title"
+		if parts := strings.SplitN(hit.Title, " - ", 2); len(parts) == 2 {
+			release.Artists = ArtistCredits{{Name: discogsArtistNumber.ReplaceAllString(parts[0], "")}}
+			release.Title = parts[1]
+		}
+		if len(hit.Barcode) != 0 {
+			release.Barcode = hit.Barcode[0]
+		}
+		for _, l := range hit.Label {
+			release.Labels = append(release.Labels, LabelInfo{Name: l, CatalogNumber: hit.Catno})
+		}
+		if len(hit.Format) != 0 {
+			quantity := hit.FormatQuantity
+			if quantity < 1 {
+				quantity = 1
+			}
+			for position := 1; position <= quantity; position++ {
+				release.Media = append(release.Media, Medium{Position: position, Format: hit.Format[0]})
+			}
+		}
+		releases = append(releases, release)
+	}
+	return releases
+}
+
+//------------------------
+
+// Releases found by AcoustID, with the best score of the results they appear in.
+// Only the tracks matching the fingerprinted file are known.
+func (r *AcoustidResults) Releases() []*Release {
+	releases := []*Release{}
+	index := map[string]*Release{}
+	for _, result := range r.Results {
+		for _, recording := range result.Recordings {
+			for _, rel := range recording.Releases {
+				release, ok := index[rel.ID]
+				if !ok {
+					release = &Release{
+						Provider: AcoustIDProvider,
+						ID:       rel.ID,
+						Title:    rel.Title,
+						Country:  rel.Country,
+						Year:     rel.Date.Year,
+					}
+					if rel.Date.Year != 0 {
+						release.Date = fmt.Sprintf("%04d", rel.Date.Year)
+						if rel.Date.Month != 0 {
+							release.Date += fmt.Sprintf("-%02d", rel.Date.Month)
+							if rel.Date.Day != 0 {
+								release.Date += fmt.Sprintf("-%02d", rel.Date.Day)
+							}
+						}
+					}
+					for _, a := range rel.Artists {
+						release.Artists = append(release.Artists, ArtistCredit{ID: a.ID, Name: a.Name})
+					}
+					setDefaultJoinPhrases(release.Artists)
+					index[rel.ID] = release
+					releases = append(releases, release)
+				}
+				if result.Score > release.Score {
+					release.Score = result.Score
+				}
+				for _, m := range rel.Mediums {
+					medium := release.medium(m.Position, m.Format)
+					medium.TrackCount = m.TrackCount
+					for _, t := range m.Tracks {
+						if medium.track(t.Position) != nil {
+							continue
+						}
+						track := Track{
+							ID:          t.ID,
+							RecordingID: recording.ID,
+							Number:      strconv.Itoa(t.Position),
+							Position:    t.Position,
+							Title:       t.Title,
+							Length:      time.Duration(recording.Duration) * time.Second,
+						}
+						for _, a := range t.Artists {
+							track.Artists = append(track.Artists, ArtistCredit{ID: a.ID, Name: a.Name})
+						}
+						setDefaultJoinPhrases(track.Artists)
+						medium.Tracks = append(medium.Tracks, track)
+					}
+				}
+			}
+		}
+	}
+	for _, release := range releases {
+		release.sortMedia()
+	}
+	return releases
+}
+
+// sortMedia and their tracks by position.
+func (r *Release) sortMedia() {
+	sort.Slice(r.Media, func(i, j int) bool { return r.Media[i].Position < r.Media[j].Position })
+	for _, m := range r.Media {
+		sort.Slice(m.Tracks, func(i, j int) bool { return m.Tracks[i].Position < m.Tracks[j].Position })
+	}
+}
+
+// setDefaultJoinPhrases when the provider only gives a list of artists.
+func setDefaultJoinPhrases(artists ArtistCredits) {
+	for i := range artists {
+		switch {
+		case i == len(artists)-1:
+		case i == len(artists)-2:
+			artists[i].JoinPhrase = " & "
+		default:
+			artists[i].JoinPhrase = ", "
+		}
+	}
+}
+
+// medium at a given position, created if necessary.
+func (r *Release) medium(position int, format string) *Medium {
+	for i := range r.Media {
+		if r.Media[i].Position == position {
+			return &r.Media[i]
+		}
+	}
+	r.Media = append(r.Media, Medium{Position: position, Format: format})
+	return &r.Media[len(r.Media)-1]
+}
+
+func (m *Medium) track(position int) *Track {
+	for i := range m.Tracks {
+		if m.Tracks[i].Position == position {
+			return &m.Tracks[i]
+		}
+	}
+	return nil
+}
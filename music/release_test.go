@@ -0,0 +1,168 @@
+package music
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trimmed AcoustID lookup response for two tracks of the same release
+const testAcoustidJSON = `{
+  "status": "ok",
+  "results": [
+    {"id": "fp1", "score": 0.95, "recordings": [
+      {"id": "r2", "title": "The Day the World Went Away", "duration": 273,
+       "artists": [{"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails"}],
+       "releases": [
+         {"id": "b84ee12a-09ef-421b-82de-0441a926375b", "title": "The Fragile", "country": "US",
+          "date": {"year": 1999, "month": 9, "day": 21}, "medium_count": 2, "track_count": 23,
+          "artists": [{"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails"}],
+          "mediums": [{"format": "CD", "position": 1, "track_count": 12, "tracks": [
+            {"id": "t2", "position": 2, "title": "The Day the World Went Away",
+             "artists": [{"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails"}]}]}]},
+         {"id": "single", "title": "The Day the World Went Away", "country": "US",
+          "date": {"year": 1999}, "medium_count": 1, "track_count": 3,
+          "mediums": [{"format": "CD", "position": 1, "track_count": 3, "tracks": [
+            {"id": "s1", "position": 1, "title": "The Day the World Went Away"}]}]}
+       ]}
+    ]},
+    {"id": "fp2", "score": 0.5, "recordings": [
+      {"id": "r3", "title": "Please", "duration": 210,
+       "releases": [
+         {"id": "b84ee12a-09ef-421b-82de-0441a926375b", "title": "The Fragile", "country": "US",
+          "date": {"year": 1999, "month": 9, "day": 21}, "medium_count": 2, "track_count": 23,
+          "artists": [{"id": "b7ffd2af-418f-4be2-bdd1-22f8b48613da", "name": "Nine Inch Nails"}],
+          "mediums": [{"format": "CD", "position": 2, "track_count": 11, "tracks": [
+            {"id": "t3", "position": 1, "title": "Please"}]}]}
+       ]}
+    ]}
+  ]
+}`
+
+func TestReleaseFromMusicBrainz(t *testing.T) {
+	fmt.Println("+ Testing releases from MusicBrainz...")
+	check := assert.New(t)
+
+	info := MusicBrainzReleaseResults{}
+	require.Nil(t, json.Unmarshal([]byte(testMusicBrainzReleaseJSON), &info))
+	r := info.Release()
+	check.Equal(MusicBrainzProvider, r.Provider)
+	check.Equal("b84ee12a-09ef-421b-82de-0441a926375b", r.ID)
+	check.Equal("7cc48eab-6ff5-37c9-9a7f-39f4abe7ffb5", r.ReleaseGroupID)
+	check.Equal("Nine Inch Nails", r.Artists.String())
+	check.Equal(1999, r.Year)
+	check.Equal("606949049020", r.Barcode)
+	check.Equal(2, len(r.Media))
+	check.Equal(3, r.TrackCount())
+	check.Equal((271+273+210)*time.Second, r.Length())
+	check.Equal("Nine Inch Nails - The Fragile (1999)", r.String())
+
+	track := r.Track(1, 2)
+	require.NotNil(t, track)
+	check.Equal("r2", track.RecordingID)
+	check.Equal(273*time.Second, track.Length)
+	check.Equal("Nine Inch Nails", track.Artists.String(), "Track artists default to release artists")
+	track = r.Track(2, 1)
+	require.NotNil(t, track)
+	check.Equal("Nine Inch Nails feat. Someone Else", track.Artists.String())
+
+	search := MusicBrainzSearchResults{}
+	require.Nil(t, json.Unmarshal([]byte(testMusicBrainzSearchJSON), &search))
+	r = search.Releases[1].Release()
+	check.Equal(1.0, r.Score)
+	check.Equal("Parlophone", r.Labels[0].Name)
+	check.Equal("527 7532", r.Labels[0].CatalogNumber)
+	require.Equal(t, 1, len(r.Media))
+	check.Equal(10, r.Media[0].TrackCount, "Search hits have track counts, without tracks")
+	check.Equal(0, len(r.Media[0].Tracks))
+	check.Equal(10, r.TrackCount())
+}
+
+func TestReleaseFromDiscogs(t *testing.T) {
+	fmt.Println("+ Testing releases from Discogs...")
+	check := assert.New(t)
+
+	details := DiscogsReleaseResults{}
+	require.Nil(t, json.Unmarshal([]byte(testDiscogsReleaseJSON), &details))
+	r := details.Release()
+	check.Equal(DiscogsProvider, r.Provider)
+	check.Equal("249504", r.ID)
+	check.Equal("96559", r.ReleaseGroupID)
+	check.Equal("Rick Astley & The Band", r.Artists.String())
+	check.Equal("5012394144777", r.Barcode)
+	check.Equal("PB 41447", r.Labels[0].CatalogNumber)
+	require.Equal(t, 1, len(r.Media))
+	check.Equal("Vinyl", r.Media[0].Format)
+	check.Equal(4, r.TrackCount())
+	track := r.Track(1, 2)
+	require.NotNil(t, track)
+	check.Equal("B1", track.Number)
+	check.Equal("Suite", track.Title)
+	check.Equal(210*time.Second, track.Length)
+
+	// multi-disc positions
+	check.Equal(2, discogsMedium("2-03", 0))
+	check.Equal(3, discogsMedium("CD3-1", 0))
+	check.Equal(1, discogsMedium("C2", 1))
+	check.Equal(2, discogsMedium("C2", 2))
+	check.Equal(1, discogsMedium("12", 0))
+	check.Equal(2, discogsMedium("2.5", 0))
+	check.Equal(1, discogsMedium("B1a", 2))
+
+	hits := DiscogsResults{}
+	require.Nil(t, json.Unmarshal([]byte(`{"results": [
+		{"id": 1388513, "type": "release", "master_id": 21491, "title": "Radiohead - Kid A", "year": "2000",
+		 "country": "UK", "label": ["Parlophone", "EMI"], "catno": "527 7532", "format": ["CD", "Album"], "format_quantity": 2,
+		 "barcode": ["724352775324", "7 24352 77532 4"]}]}`), &hits))
+	releases := hits.Releases()
+	require.Equal(t, 1, len(releases))
+	check.Equal("Kid A", releases[0].Title)
+	check.Equal("Radiohead", releases[0].Artists.String())
+	check.Equal(2000, releases[0].Year)
+	check.Equal("21491", releases[0].ReleaseGroupID)
+	check.Equal(2, len(releases[0].Labels))
+	check.Equal("527 7532", releases[0].Labels[1].CatalogNumber)
+	check.Equal("724352775324", releases[0].Barcode)
+	require.Equal(t, 2, len(releases[0].Media))
+	check.Equal("CD", releases[0].Media[1].Format)
+	check.Equal(0, releases[0].TrackCount())
+}
+
+func TestReleaseFromAcoustid(t *testing.T) {
+	fmt.Println("+ Testing releases from AcoustID...")
+	check := assert.New(t)
+
+	results := AcoustidResults{}
+	require.Nil(t, json.Unmarshal([]byte(testAcoustidJSON), &results))
+	releases := results.Releases()
+	require.Equal(t, 2, len(releases))
+
+	r := releases[0]
+	check.Equal(AcoustIDProvider, r.Provider)
+	check.Equal("b84ee12a-09ef-421b-82de-0441a926375b", r.ID)
+	check.Equal(0.95, r.Score)
+	check.Equal("1999-09-21", r.Date)
+	check.Equal("Nine Inch Nails", r.Artists.String())
+	require.Equal(t, 2, len(r.Media))
+	check.Equal(2, len(r.Tracks()), "Only the fingerprinted tracks are known")
+	check.Equal(12, r.Media[0].TrackCount)
+	check.Equal(23, r.TrackCount())
+	track := r.Track(1, 2)
+	require.NotNil(t, track)
+	check.Equal("r2", track.RecordingID)
+	check.Equal(273*time.Second, track.Length)
+	track = r.Track(2, 1)
+	require.NotNil(t, track)
+	check.Equal("Please", track.Title)
+
+	check.Equal("single", releases[1].ID)
+	check.Equal("1999", releases[1].Date)
+
+	artists := ArtistCredits{{Name: "A"}, {Name: "B"}, {Name: "C"}}
+	setDefaultJoinPhrases(artists)
+	check.Equal("A, B & C", artists.String())
+}